@@ -0,0 +1,102 @@
+// Package apierr defines the errors returned by the fabreview ReviewContract.
+//
+// A transaction that fails on purpose, eg because the review doesn't exist or the caller may not
+// change it, returns a JSON encoded Error as its message, so clients can branch on its Code and show
+// the errors of each field next to its input, instead of matching on text:
+//
+//	{"code":"NOT_FOUND","message":"the review 01JQ5D7V0Z6M4S8XW6G4D7F9BN does not exist"}
+//
+// Parse decodes it from the message of a failed evaluation or endorsement, wherever the Fabric
+// client library nests it. Any other message, eg from a failure reading the ledger, parses as Unknown.
+package apierr
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Code classifies an Error
+type Code string
+
+const (
+	NotFound        Code = "NOT_FOUND"
+	AlreadyExists   Code = "ALREADY_EXISTS"
+	Forbidden       Code = "FORBIDDEN"        // the caller's role or identity doesn't allow it
+	InvalidArgument Code = "INVALID_ARGUMENT" // see Fields for the invalid fields, if any
+	Conflict        Code = "CONFLICT"         // the current state doesn't allow it, eg restoring a review that isn't deleted
+	Unknown         Code = "UNKNOWN"          // the message isn't an Error, see Parse
+)
+
+// Codes of FieldError
+const (
+	Required        = "required"
+	TooShort        = "too_short"
+	TooLong         = "too_long"
+	TooMany         = "too_many"
+	InvalidFormat   = "invalid_format"
+	OutOfRange      = "out_of_range"
+	InvalidEncoding = "invalid_encoding"
+)
+
+// FieldError is a validation failure of one input field
+type FieldError struct {
+	Field   string `json:"field"` // JSON path of the field, eg title, positives[1] or extra_info.name
+	Code    string `json:"code"`  // see the FieldError codes, eg too_long
+	Message string `json:"message"`
+}
+
+// Error is an error returned by the contract
+type Error struct {
+	Code    Code         `json:"code"`
+	Message string       `json:"message"`          // human readable, not meant to be matched on
+	Fields  []FieldError `json:"fields,omitempty"` // set for InvalidArgument errors of input fields
+}
+
+// New returns an Error with a formatted message
+func New(code Code, format string, args ...any) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// Error returns the JSON encoded Error
+func (e *Error) Error() string {
+	errorJSON, err := json.Marshal(e)
+	if err != nil {
+		return e.Message
+	}
+	return string(errorJSON)
+}
+
+// envelopePrefix starts every encoded Error
+const envelopePrefix = `{"code":`
+
+// Parse decodes the Error in the message of a failed transaction. The Fabric client libraries wrap it,
+// eg "chaincode response 500, {...}", so the Error is looked up anywhere in the message. A message
+// without one parses as an Unknown Error holding the whole message
+func Parse(message string) *Error {
+	if i := strings.Index(message, envelopePrefix); i >= 0 {
+		var e Error
+		if err := json.NewDecoder(strings.NewReader(message[i:])).Decode(&e); err == nil && e.Code != "" {
+			return &e
+		}
+	}
+	return &Error{Code: Unknown, Message: message}
+}
+
+// CodeOf returns the code of err, which may be an Error or an error whose message holds one
+func CodeOf(err error) Code {
+	if err == nil {
+		return ""
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	return Parse(err.Error()).Code
+}
+
+// Is reports whether err is an Error with the given code
+func Is(err error, code Code) bool {
+	return CodeOf(err) == code
+}
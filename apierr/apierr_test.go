@@ -0,0 +1,62 @@
+package apierr
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestError(t *testing.T) {
+	err := New(NotFound, "the review %s does not exist", "01JQ5D7V0Z6M4S8XW6G4D7F9BN")
+	if got, want := err.Error(), `{"code":"NOT_FOUND","message":"the review 01JQ5D7V0Z6M4S8XW6G4D7F9BN does not exist"}`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestParse(t *testing.T) {
+	err := &Error{
+		Code:    InvalidArgument,
+		Message: "invalid title",
+		Fields:  []FieldError{{Field: "title", Code: TooLong, Message: "must be at most 128 characters"}},
+	}
+
+	for _, message := range []string{
+		err.Error(),
+		"chaincode response 500, " + err.Error(),
+		"rpc error: code = Aborted desc = failed to endorse transaction, see attached details for more info\n" +
+			"details: address: peer0.org1:7051, mspId: Org1MSP, message: chaincode response 500, " + err.Error(),
+	} {
+		if got := Parse(message); !reflect.DeepEqual(got, err) {
+			t.Errorf("Parse(%q) = %+v, want %+v", message, got, err)
+		}
+	}
+
+	for _, message := range []string{"failed to read from world state: timeout", `{"code":`, `{"message":"no code"}`} {
+		if got := Parse(message); got.Code != Unknown || got.Message != message {
+			t.Errorf("Parse(%q) = %+v, want Unknown", message, got)
+		}
+	}
+}
+
+func TestCodeOf(t *testing.T) {
+	tests := []struct {
+		err  error
+		want Code
+	}{
+		{nil, ""},
+		{New(Forbidden, "unauthorized"), Forbidden},
+		{fmt.Errorf("failed to delete review: %w", New(Conflict, "the review is hidden")), Conflict},
+		{errors.New("endorsement failed: " + New(AlreadyExists, "the review exists").Error()), AlreadyExists},
+		{errors.New("connection refused"), Unknown},
+	}
+	for _, tt := range tests {
+		if got := CodeOf(tt.err); got != tt.want {
+			t.Errorf("CodeOf(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+
+	if !Is(New(NotFound, "gone"), NotFound) || Is(New(NotFound, "gone"), Conflict) {
+		t.Error("Is doesn't compare codes")
+	}
+}
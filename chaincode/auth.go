@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/edgeflare/fabreview/apierr"
+	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Role decides which transactions a caller may submit. Roles are ordered, each includes the ones before it
+type Role string
+
+const (
+	RoleReadOnly  Role = "read-only" // queries only
+	RoleReviewer  Role = "reviewer"  // writes reviews, comments, votes and flags
+	RoleModerator Role = "moderator" // works the moderation queue
+	RoleOrgAdmin  Role = "org-admin" // changes settings, purges, compacts and migrates
+)
+
+var roles = []Role{RoleReadOnly, RoleReviewer, RoleModerator, RoleOrgAdmin}
+
+// roleAttribute is the certificate attribute the CA sets to one of the roles, like it sets hf.Registrar.Roles, eg
+//
+//	"attrs": [{"name": "fabreview.role", "value": "moderator", "ecert": true}]
+const roleAttribute = "fabreview.role"
+
+// requiredRoles declares the role each transaction requires. Transactions not listed here are refused
+var requiredRoles = map[string]Role{
+	// reviews
+	"ReviewExists":      RoleReadOnly,
+	"ReadReview":        RoleReadOnly,
+	"ReadReviewPrivate": RoleReadOnly,
+	"ReadAllReviews":    RoleReadOnly,
+	"ReadReviewsPage":   RoleReadOnly,
+	"QueryReviews":      RoleReadOnly,
+	"CountReviews":      RoleReadOnly,
+	"GetReviewHistory":  RoleReadOnly,
+	"GetEntityStats":    RoleReadOnly,
+	"GetCounts":         RoleReadOnly,
+	"CreateReview":      RoleReviewer,
+	"CreateReviewV2":    RoleReviewer,
+	"UpdateReview":      RoleReviewer,
+	"PatchReview":       RoleReviewer,
+	"MergePatchReview":  RoleReviewer,
+	"DeleteReview":      RoleReviewer,
+	"DeleteReviewV2":    RoleReviewer,
+	"RestoreReview":     RoleReviewer,
+	"PurgeReview":       RoleOrgAdmin,
+	"AnchorEvidence":    RoleReviewer,
+	"VerifyEvidence":    RoleReadOnly,
+
+	// comments, responses and votes
+	"AddComment":                 RoleReviewer,
+	"AddReply":                   RoleReviewer,
+	"EditComment":                RoleReviewer,
+	"DeleteComment":              RoleReviewer,
+	"GetCommentThread":           RoleReadOnly,
+	"PostOfficialResponse":       RoleReviewer,
+	"GetOfficialResponseHistory": RoleReadOnly,
+	"Vote":                       RoleReviewer,
+	"GetVoteTally":               RoleReadOnly,
+	"CompactVotes":               RoleOrgAdmin,
+
+	// entities
+	"ListEntities":   RoleReadOnly,
+	"RegisterEntity": RoleModerator,
+	"MergeEntities":  RoleModerator,
+
+	// moderation
+	"FlagReview":       RoleReviewer,
+	"FlagComment":      RoleReviewer,
+	"GetModerationLog": RoleReadOnly,
+	"ListFlagged":      RoleModerator,
+	"ResolveFlag":      RoleModerator,
+	"RestoreContent":   RoleModerator,
+
+	// administration
+	"GetRole":             RoleReadOnly,
+	"ClaimLegacyIdentity": RoleReviewer,
+	"GetConfig":           RoleReadOnly,
+	"SetConfig":           RoleOrgAdmin,
+	"SetPseudonymSecret":  RoleOrgAdmin,
+	"MigrateInteractions": RoleOrgAdmin,
+	"MigrateBatch":        RoleOrgAdmin,
+	"MigrateKeys":         RoleOrgAdmin,
+	"RebuildStats":        RoleOrgAdmin,
+	"RecountAll":          RoleOrgAdmin,
+	"CompactCounts":       RoleOrgAdmin,
+	"InitLedger":          RoleOrgAdmin,
+	"AddSampleComments":   RoleOrgAdmin,
+}
+
+// parseRole returns the role named s
+func parseRole(s string) (Role, error) {
+	role := Role(strings.TrimSpace(s))
+	if !slices.Contains(roles, role) {
+		return "", fmt.Errorf("unknown role %q", s)
+	}
+	return role, nil
+}
+
+// includes reports whether r grants everything other does
+func (r Role) includes(other Role) bool {
+	return slices.Index(roles, r) >= slices.Index(roles, other)
+}
+
+// callerRole resolves the caller's role from their certificate and MSP:
+//   - CA admins, ie hf.Type set to admin, are org admins
+//   - otherwise the fabreview.role attribute applies when present
+//   - otherwise the role configured for the caller's MSP, or reviewer
+//
+// Only the CAs of the admin MSPs are trusted with the moderator and org-admin roles. Other MSPs' CA admins
+// and role attributes granting more than reviewer are ignored, so an org can't make its own members moderators
+func callerRole(ctx contractapi.TransactionContextInterface) (Role, error) {
+	clientIdentity, err := cid.New(ctx.GetStub())
+	if err != nil {
+		return "", fmt.Errorf("failed to get client identity: %v", err)
+	}
+
+	mspID, err := clientIdentity.GetMSPID()
+	if err != nil {
+		return "", fmt.Errorf("failed to get MSP ID: %v", err)
+	}
+	config, err := readConfig(ctx)
+	if err != nil {
+		return "", err
+	}
+	trusted := slices.Contains(config.AdminMSPs, mspID)
+
+	if trusted {
+		if err := clientIdentity.AssertAttributeValue("hf.Type", "admin"); err == nil {
+			return RoleOrgAdmin, nil
+		}
+	}
+
+	value, found, err := clientIdentity.GetAttributeValue(roleAttribute)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s attribute: %v", roleAttribute, err)
+	}
+	if found {
+		role, err := parseRole(value)
+		if err != nil {
+			return "", err
+		}
+		if trusted || RoleReviewer.includes(role) {
+			return role, nil
+		}
+	}
+
+	if role, ok := config.MSPRoles[mspID]; ok {
+		return parseRole(role)
+	}
+
+	return RoleReviewer, nil
+}
+
+// authorize runs before every transaction and refuses callers without the role the transaction requires
+func authorize(ctx contractapi.TransactionContextInterface) error {
+	function, _ := ctx.GetStub().GetFunctionAndParameters()
+	// the contract name prefix, eg ReviewContract:CreateReview, is optional
+	function = function[strings.LastIndex(function, ":")+1:]
+
+	required, ok := requiredRoles[function]
+	if !ok {
+		return apierr.New(apierr.Forbidden, "unauthorized: %s doesn't declare a required role", function)
+	}
+
+	role, err := callerRole(ctx)
+	if err != nil {
+		return err
+	}
+	if !role.includes(required) {
+		return apierr.New(apierr.Forbidden, "unauthorized: %s requires the %s role, caller is %s", function, required, role)
+	}
+
+	return nil
+}
+
+// GetBeforeTransaction makes contractapi call authorize before each transaction
+func (s *ReviewContract) GetBeforeTransaction() interface{} {
+	return authorize
+}
+
+// GetRole returns the caller's role
+func (s *ReviewContract) GetRole(ctx contractapi.TransactionContextInterface) (string, error) {
+	role, err := callerRole(ctx)
+	return string(role), err
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/edgeflare/fabreview/apierr"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+func TestRequiredRolesCoverTransactions(t *testing.T) {
+	contractType := reflect.TypeOf(&ReviewContract{})
+	for i := range contractType.NumMethod() {
+		name := contractType.Method(i).Name
+		if _, inherited := reflect.TypeOf(&contractapi.Contract{}).MethodByName(name); inherited || name == "GetBeforeTransaction" {
+			continue
+		}
+		if _, ok := requiredRoles[name]; !ok {
+			t.Errorf("%s doesn't declare a required role", name)
+		}
+	}
+}
+
+func TestCallerRole(t *testing.T) {
+	s := newTestStub(t)
+	s.mustInvoke(admin, "SetConfig", toJSON(t, Config{RestoreWindowHours: 1, FlagThreshold: 1, MSPRoles: map[string]string{"Org3MSP": string(RoleReadOnly)}}))
+
+	tests := []struct {
+		name   string
+		caller identity
+		want   Role
+	}{
+		{"CA admin", admin, RoleOrgAdmin},
+		{"role attribute", moderator, RoleModerator},
+		{"read-only attribute", reader, RoleReadOnly},
+		{"no attribute", alice, RoleReviewer},
+		{"MSP role", identity{mspID: "Org3MSP", cn: "erin"}, RoleReadOnly},
+		{"CA admin of another MSP", identity{mspID: "Org2MSP", cn: "admin", attrs: map[string]string{"hf.Type": "admin"}}, RoleReviewer},
+		{"moderator of another MSP", identity{mspID: "Org2MSP", cn: "mod", attrs: map[string]string{roleAttribute: string(RoleModerator)}}, RoleReviewer},
+		{"org admin of another MSP with an MSP role", identity{mspID: "Org3MSP", cn: "mod", attrs: map[string]string{roleAttribute: string(RoleOrgAdmin)}}, RoleReadOnly},
+		{"read-only attribute of another MSP", identity{mspID: "Org2MSP", cn: "ro", attrs: map[string]string{roleAttribute: string(RoleReadOnly)}}, RoleReadOnly},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Role(s.mustInvoke(tt.caller, "GetRole")); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	s.wantError(apierr.Forbidden, moderator, "SetConfig", toJSON(t, Config{FlagThreshold: 1}))
+}
+
+func TestAdminMSPs(t *testing.T) {
+	s := newTestStub(t)
+	org2Admin := identity{mspID: "Org2MSP", cn: "admin", attrs: map[string]string{"hf.Type": "admin"}}
+
+	s.wantError(apierr.Forbidden, org2Admin, "SetConfig", toJSON(t, Config{FlagThreshold: 1, AdminMSPs: []string{"Org2MSP"}}))
+	s.wantError(apierr.InvalidArgument, admin, "SetConfig", toJSON(t, Config{FlagThreshold: 1, AdminMSPs: []string{" "}}))
+	s.mustInvoke(admin, "SetConfig", toJSON(t, Config{FlagThreshold: 1, AdminMSPs: []string{"Org2MSP"}}))
+
+	// the stored list replaces the environment's
+	if got := Role(s.mustInvoke(org2Admin, "GetRole")); got != RoleOrgAdmin {
+		t.Errorf("got %s for the admin of Org2MSP, want %s", got, RoleOrgAdmin)
+	}
+	if got := Role(s.mustInvoke(admin, "GetRole")); got != RoleReviewer {
+		t.Errorf("got %s for the admin of Org1MSP, want %s", got, RoleReviewer)
+	}
+}
+
+func TestNoAdminMSPs(t *testing.T) {
+	s := newTestStub(t)
+	t.Setenv(adminMSPsEnv, "")
+
+	if got := Role(s.mustInvoke(admin, "GetRole")); got != RoleReviewer {
+		t.Errorf("got %s without admin MSPs, want %s", got, RoleReviewer)
+	}
+	s.wantError(apierr.Forbidden, admin, "SetConfig", toJSON(t, Config{FlagThreshold: 1}))
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/edgeflare/fabreview/apierr"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Settings live on the ledger rather than in the chaincode server's environment,
+// so every endorsing peer applies the same values.
+const configObjectType = "config" // config~
+
+// adminMSPsEnv names the environment variable listing, comma separated, the admin MSPs in effect until
+// admin_msps is set. It can't be on the ledger, since setting the config takes an org admin already
+const adminMSPsEnv = "FABREVIEW_ADMIN_MSPS"
+
+// Config holds the contract settings admins can change
+type Config struct {
+	RestoreWindowHours int `json:"restore_window_hours"` // how long a deleted review can be restored before it may be purged
+	FlagThreshold      int `json:"flag_threshold"`       // number of users flagging a review or comment before it's hidden
+	// IDTimeToleranceSeconds bounds how far the time of a new review or comment ULID may be from the
+	// transaction time. 0 disables the check
+	IDTimeToleranceSeconds int `json:"id_time_tolerance_seconds"`
+	// AdminMSPs lists the MSPs whose CA is trusted to make users moderators or org admins, with hf.Type or the
+	// fabreview.role attribute. The CAs of other MSPs can't grant more than the reviewer role
+	AdminMSPs []string `json:"admin_msps,omitzero" metadata:",optional"`
+	// MSPRoles maps an MSP ID to the role of its members whose certificate carries no role attribute.
+	// Members of unlisted MSPs are reviewers
+	MSPRoles map[string]string `json:"msp_roles,omitzero" metadata:",optional"`
+	// SubjectAttribute names the certificate attribute holding the user's OIDC sub claim, eg hf.EnrollmentID
+	// when the CA enrolls users under their subject. Certificates carrying it are identified by it rather than by the certificate itself
+	SubjectAttribute string `json:"subject_attribute,omitzero" metadata:",optional"`
+	// LegacyMSP is the MSP whose members wrote reviews when the certificate CommonName was the user ID.
+	// Only its members may claim a CommonName with ClaimLegacyIdentity
+	LegacyMSP string `json:"legacy_msp,omitzero" metadata:",optional"`
+	// PrivateExtraInfoKeys lists the extra info keys that may only be passed as private details
+	PrivateExtraInfoKeys []string `json:"private_extra_info_keys,omitzero" metadata:",optional"`
+	// DocType and SchemaVersion identify the stored document, see schemaVersion
+	DocType       string `json:"doc_type,omitzero" metadata:",optional"`
+	SchemaVersion int    `json:"schema_version,omitzero" metadata:",optional"`
+}
+
+// defaultConfig returns the config used until an admin calls SetConfig. It's built on every call, so that
+// decoding the stored config over it can't write into the defaults, like into the array of a shared slice
+func defaultConfig() Config {
+	return Config{
+		RestoreWindowHours:     30 * 24,
+		FlagThreshold:          3,
+		IDTimeToleranceSeconds: 5 * 60,
+		PrivateExtraInfoKeys:   []string{"name", "email", "phone", "address"},
+	}
+}
+
+// configKey returns the key the config is stored under. Being a composite key, range scans over reviews don't see it
+func configKey(ctx contractapi.TransactionContextInterface) (string, error) {
+	return ctx.GetStub().CreateCompositeKey(configObjectType, []string{})
+}
+
+// envAdminMSPs returns the MSPs listed in adminMSPsEnv
+func envAdminMSPs() []string {
+	var mspIDs []string
+	for _, mspID := range strings.Split(os.Getenv(adminMSPsEnv), ",") {
+		if mspID = strings.TrimSpace(mspID); mspID != "" {
+			mspIDs = append(mspIDs, mspID)
+		}
+	}
+	return mspIDs
+}
+
+// readConfig returns the stored config, or defaultConfig if none was set. Without admin MSPs, those of
+// adminMSPsEnv apply
+func readConfig(ctx contractapi.TransactionContextInterface) (*Config, error) {
+	key, err := configKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	configJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+
+	config := defaultConfig()
+	if configJSON != nil {
+		if err := decodeDocument(configObjectType, configJSON, &config); err != nil {
+			return nil, err
+		}
+	}
+	if len(config.AdminMSPs) == 0 {
+		config.AdminMSPs = envAdminMSPs()
+	}
+
+	return &config, nil
+}
+
+// GetConfig returns the contract settings currently in effect
+func (s *ReviewContract) GetConfig(ctx contractapi.TransactionContextInterface) (*Config, error) {
+	return readConfig(ctx)
+}
+
+// SetConfig replaces the contract settings. Only admins may change them
+func (s *ReviewContract) SetConfig(ctx contractapi.TransactionContextInterface, config Config) error {
+	if config.RestoreWindowHours < 0 {
+		return apierr.New(apierr.InvalidArgument, "restore window can't be negative")
+	}
+	if config.FlagThreshold < 1 {
+		return apierr.New(apierr.InvalidArgument, "flag threshold must be at least 1")
+	}
+	if config.IDTimeToleranceSeconds < 0 {
+		return apierr.New(apierr.InvalidArgument, "ID time tolerance can't be negative")
+	}
+	for _, mspID := range config.AdminMSPs {
+		if strings.TrimSpace(mspID) == "" {
+			return apierr.New(apierr.InvalidArgument, "admin MSP IDs can't be empty")
+		}
+	}
+	for mspID, role := range config.MSPRoles {
+		if _, err := parseRole(role); err != nil {
+			return apierr.New(apierr.InvalidArgument, "invalid role for MSP %s: %v", mspID, err)
+		}
+	}
+
+	key, err := configKey(ctx)
+	if err != nil {
+		return err
+	}
+
+	config.DocType, config.SchemaVersion = configObjectType, schemaVersion
+	configJSON, err := json.Marshal(config)
+	if err != nil {
+		return fmt.Errorf("failed to marshal config: %v", err)
+	}
+
+	return ctx.GetStub().PutState(key, configJSON)
+}
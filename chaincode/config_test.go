@@ -0,0 +1,49 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/edgeflare/fabreview/apierr"
+)
+
+func TestConfig(t *testing.T) {
+	s := newTestStub(t)
+
+	var config Config
+	s.mustInvokeJSON(&config, reader, "GetConfig")
+	if config.FlagThreshold != defaultConfig().FlagThreshold || !slices.Equal(config.AdminMSPs, []string{"Org1MSP"}) {
+		t.Errorf("got %+v, want the defaults", config)
+	}
+
+	for _, invalid := range []Config{
+		{RestoreWindowHours: -1, FlagThreshold: 1},
+		{FlagThreshold: 0},
+		{FlagThreshold: 1, IDTimeToleranceSeconds: -1},
+		{FlagThreshold: 1, MSPRoles: map[string]string{"Org2MSP": "owner"}},
+	} {
+		s.wantError(apierr.InvalidArgument, admin, "SetConfig", toJSON(t, invalid))
+	}
+
+	s.mustInvoke(admin, "SetConfig", toJSON(t, Config{FlagThreshold: 5, PrivateExtraInfoKeys: []string{"ssn"}}))
+	s.mustInvokeJSON(&config, reader, "GetConfig")
+	if config.FlagThreshold != 5 || !slices.Equal(config.PrivateExtraInfoKeys, []string{"ssn"}) {
+		t.Errorf("got %+v after SetConfig", config)
+	}
+}
+
+func TestStoredConfigKeepsDefaults(t *testing.T) {
+	s := newTestStub(t)
+	s.mustInvoke(admin, "SetConfig", toJSON(t, Config{FlagThreshold: 3, PrivateExtraInfoKeys: []string{"ssn"}}))
+	s.mustInvoke(reader, "GetConfig")
+
+	if keys := defaultConfig().PrivateExtraInfoKeys; !slices.Equal(keys, []string{"name", "email", "phone", "address"}) {
+		t.Errorf("reading the stored config changed the default private keys to %v", keys)
+	}
+
+	// another ledger, without a stored config, still treats the default keys as private
+	other := newTestStub(t)
+	input := other.reviewInput("example.com")
+	input.ExtraInfo = map[string]string{"name": "A. Manager"}
+	other.wantError(apierr.InvalidArgument, alice, "CreateReviewV2", toJSON(t, input))
+}
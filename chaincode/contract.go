@@ -1,10 +1,12 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
 	"log"
+	"time"
 
+	"github.com/edgeflare/fabreview/apierr"
+	"github.com/edgeflare/fabreview/events"
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
 	"github.com/oklog/ulid/v2"
 )
@@ -20,6 +22,18 @@ type QueryResult struct {
 	Record *Review
 }
 
+// PaginatedQueryResult structure used for returning a page of query results
+type PaginatedQueryResult struct {
+	Records      []QueryResult `json:"records"`
+	FetchedCount int32         `json:"fetched_count"` // len(Records)
+	Bookmark     string        `json:"bookmark"`      // opaque, pass back to fetch the next page
+}
+
+const (
+	// maxPageSize caps the number of records returned by paginated queries
+	maxPageSize = 100
+)
+
 type VoteType int8
 
 const (
@@ -29,15 +43,30 @@ const (
 )
 
 type Vote struct {
-	UserID string   `json:"user_id"`
-	Value  VoteType `json:"value"`
+	UserID    string    `json:"user_id"`
+	Value     VoteType  `json:"value"`
+	UpdatedAt time.Time `json:"updated_at,omitzero" metadata:",optional"` // when the vote was last cast
+	// DocType and SchemaVersion identify the stored document, see schemaVersion. Absent in votes embedded in reviews
+	DocType       string `json:"doc_type,omitzero" metadata:",optional"`
+	SchemaVersion int    `json:"schema_version,omitzero" metadata:",optional"`
 }
 
 type Comment struct {
-	ID      string `json:"id"` // ULID
-	UserID  string `json:"user_id"`
-	Comment string `json:"comment"` // max 4096 chars
-	Votes   []Vote `json:"votes,omitzero"`
+	ID        string    `json:"id"` // ULID
+	UserID    string    `json:"user_id"`
+	Comment   string    `json:"comment"`                                 // max 4096 chars, deletedPlaceholder once deleted if it has replies
+	ParentID  string    `json:"parent_id,omitzero" metadata:",optional"` // comment replied to, see AddReply
+	Depth     int       `json:"depth,omitzero" metadata:",optional"`     // number of comments above it in its thread
+	Deleted   bool      `json:"deleted,omitzero" metadata:",optional"`   // set on the placeholder of a deleted comment with replies
+	Votes     []Vote    `json:"votes,omitzero" metadata:",optional"`
+	Hidden    *Hidden   `json:"hidden,omitzero" metadata:",optional"`     // set when hidden by moderation
+	CreatedAt time.Time `json:"created_at,omitzero" metadata:",optional"` // transaction time, absent in comments written before it was recorded
+	UpdatedAt time.Time `json:"updated_at,omitzero" metadata:",optional"` // idem
+	Edited    bool      `json:"edited,omitzero" metadata:",optional"`
+	Revision  int       `json:"revision,omitzero" metadata:",optional"` // 1 when created, incremented by each edit
+	// DocType and SchemaVersion identify the stored document, see schemaVersion. Absent in comments embedded in reviews
+	DocType       string `json:"doc_type,omitzero" metadata:",optional"`
+	SchemaVersion int    `json:"schema_version,omitzero" metadata:",optional"`
 }
 
 const (
@@ -46,59 +75,140 @@ const (
 
 // Review describes basic details of what makes up a simple review
 type Review struct {
-	ID        string            `json:"id"`                  // ULID
-	Title     string            `json:"title"`               // max 128 chars
-	Website   string            `json:"website"`             // max 64 chars
-	Summary   string            `json:"summary"`             // max 4096 chars
-	Rating    uint8             `json:"rating"`              // between 1 and 10
-	Country   string            `json:"country"`             // max 2 chars eg BD
-	State     string            `json:"state"`               // province, region, county or state. max 32 chars
-	Locality  string            `json:"locality"`            // town, city, village, etc. name. max 32 chars
-	Email     string            `json:"email,omitzero"`      // max 32 chars
-	Phone     string            `json:"phone,omitzero"`      // max 32 chars
-	Positives []string          `json:"positives,omitzero"`  // max 32 chars each
-	Negatives []string          `json:"negatives,omitzero"`  // max 32 chars each
-	ExtraInfo map[string]string `json:"extra_info,omitzero"` // JSON object
-	Votes     []Vote            `json:"votes,omitzero"`
-	Comments  []Comment         `json:"comments,omitzero"`
-	UserID    string            `json:"user_id"` // CommonName in user's MSP certificate
+	ID        string            `json:"id"`                                       // ULID
+	Title     string            `json:"title"`                                    // max 128 chars
+	Website   string            `json:"website"`                                  // max 64 chars
+	Summary   string            `json:"summary"`                                  // max 4096 chars
+	Rating    uint8             `json:"rating"`                                   // between 1 and 10
+	Country   string            `json:"country"`                                  // max 2 chars eg BD
+	State     string            `json:"state"`                                    // province, region, county or state. max 32 chars
+	Locality  string            `json:"locality"`                                 // town, city, village, etc. name. max 32 chars
+	EntityID  string            `json:"entity_id,omitzero" metadata:",optional"`  // entity registered for the website, see RegisterEntity
+	Email     string            `json:"email,omitzero" metadata:",optional"`      // only in documents written before contact details became private
+	Phone     string            `json:"phone,omitzero" metadata:",optional"`      // idem
+	Positives []string          `json:"positives,omitzero" metadata:",optional"`  // max 32 chars each
+	Negatives []string          `json:"negatives,omitzero" metadata:",optional"`  // max 32 chars each
+	ExtraInfo map[string]string `json:"extra_info,omitzero" metadata:",optional"` // JSON object
+	Votes     []Vote            `json:"votes,omitzero" metadata:",optional"`
+	Comments  []Comment         `json:"comments,omitzero" metadata:",optional"`
+	// OfficialResponse is the reviewed entity's answer, only set when the review is read, see PostOfficialResponse
+	OfficialResponse *OfficialResponse `json:"official_response,omitzero" metadata:",optional"`
+	UserID           string            `json:"user_id"`                                  // author pseudonym, see authorID
+	Tombstone        *Tombstone        `json:"tombstone,omitzero" metadata:",optional"`  // set when the author deleted the review
+	Hidden           *Hidden           `json:"hidden,omitzero" metadata:",optional"`     // set when hidden by moderation
+	CreatedAt        time.Time         `json:"created_at,omitzero" metadata:",optional"` // transaction time, absent in reviews written before it was recorded
+	UpdatedAt        time.Time         `json:"updated_at,omitzero" metadata:",optional"` // idem
+	Edited           bool              `json:"edited,omitzero" metadata:",optional"`
+	Revision         int               `json:"revision,omitzero" metadata:",optional"` // 1 when created, incremented by each update
+	// PrivateCollection and PrivateHash are set when the review has private details, see ReadReviewPrivate
+	PrivateCollection string `json:"private_collection,omitzero" metadata:",optional"`
+	PrivateHash       string `json:"private_hash,omitzero" metadata:",optional"` // SHA-256 of the stored private details
+	// DocType and SchemaVersion identify the stored document, see schemaVersion
+	DocType       string `json:"doc_type,omitzero" metadata:",optional"`
+	SchemaVersion int    `json:"schema_version,omitzero" metadata:",optional"`
+}
+
+// ReviewInput holds the details of a new review. Contact details aren't part of it,
+// they're passed in the transient map, see ReviewPrivate
+type ReviewInput struct {
+	ID        string            `json:"id"`                                       // ULID
+	Title     string            `json:"title"`                                    // max 128 chars
+	Website   string            `json:"website"`                                  // max 64 chars
+	Summary   string            `json:"summary"`                                  // max 4096 chars
+	Rating    uint8             `json:"rating"`                                   // between 1 and 10
+	Country   string            `json:"country"`                                  // max 2 chars eg BD
+	State     string            `json:"state,omitzero" metadata:",optional"`      // max 32 chars
+	Locality  string            `json:"locality,omitzero" metadata:",optional"`   // max 32 chars
+	Positives []string          `json:"positives,omitzero" metadata:",optional"`  // max 32 chars each
+	Negatives []string          `json:"negatives,omitzero" metadata:",optional"`  // max 32 chars each
+	ExtraInfo map[string]string `json:"extra_info,omitzero" metadata:",optional"` // JSON object
+}
+
+// ReviewPatch holds the changes to a review. Fields left empty keep their current value
+type ReviewPatch struct {
+	ID        string            `json:"id"`
+	Title     string            `json:"title,omitzero" metadata:",optional"`
+	Website   string            `json:"website,omitzero" metadata:",optional"`
+	Summary   string            `json:"summary,omitzero" metadata:",optional"`
+	Rating    uint8             `json:"rating,omitzero" metadata:",optional"`
+	Country   string            `json:"country,omitzero" metadata:",optional"`
+	State     string            `json:"state,omitzero" metadata:",optional"`
+	Locality  string            `json:"locality,omitzero" metadata:",optional"`
+	Positives []string          `json:"positives,omitzero" metadata:",optional"`
+	Negatives []string          `json:"negatives,omitzero" metadata:",optional"`
+	ExtraInfo map[string]string `json:"extra_info,omitzero" metadata:",optional"`
+}
+
+// Tombstone records who deleted a review, when and why. Deleted reviews stay in world state,
+// hidden from listings, until they are restored or purged
+type Tombstone struct {
+	DeletedAt time.Time `json:"deleted_at"`
+	DeletedBy string    `json:"deleted_by"`
+	Reason    string    `json:"reason,omitzero" metadata:",optional"` // max 256 chars
 }
 
 // ReviewExists returns true when a review with the specified ID exists in world state
 func (s *ReviewContract) ReviewExists(ctx contractapi.TransactionContextInterface, id string) (bool, error) {
-	reviewJSON, err := ctx.GetStub().GetState(id)
+	reviewJSON, err := getReviewState(ctx, id)
 	if err != nil {
-		return false, fmt.Errorf("failed to read from world state: %v", err)
+		return false, err
 	}
 	return reviewJSON != nil, nil
 }
 
-// CreateReview issues a new review to the world state with given details
+// CreateReview issues a new review to the world state with given details.
+// It's kept for compatibility, see CreateReviewV2. email and phone are stored in the private
+// collection, but stay readable in the arguments of the transaction
 func (s *ReviewContract) CreateReview(ctx contractapi.TransactionContextInterface,
 	id string, title, website, summary, country, state, locality, email, phone, positives, negatives, extraInfo string, rating uint8) error {
 
-	exists, err := s.ReviewExists(ctx, id)
+	input, err := reviewInputFromArgs(id, title, website, summary, country, state, locality, positives, negatives, extraInfo, rating)
 	if err != nil {
 		return err
 	}
-	if exists {
-		return fmt.Errorf("the review %s already exists", id)
+
+	return s.createClientReview(ctx, input, email, phone)
+}
+
+// CreateReviewV2 issues a new review to the world state. Contact details and sensitive extra info
+// are passed in the transient map under review_private, see ReviewPrivate
+func (s *ReviewContract) CreateReviewV2(ctx contractapi.TransactionContextInterface, input ReviewInput) error {
+	return s.createClientReview(ctx, &input, "", "")
+}
+
+// createClientReview creates the review a client submitted, with the private details passed in the
+// transient map and the contact details passed as arguments to CreateReview
+func (s *ReviewContract) createClientReview(ctx contractapi.TransactionContextInterface, input *ReviewInput, email, phone string) error {
+	if err := checkIDTime(ctx, "id", input.ID); err != nil {
+		return err
+	}
+
+	if err := assertNoPrivateExtraInfo(ctx, input.ExtraInfo); err != nil {
+		return err
+	}
+
+	private, err := readPrivateInput(ctx, input.ID)
+	if err != nil {
+		return err
+	}
+	private, err = withContact(ctx, input.ID, private, email, phone)
+	if err != nil {
+		return err
 	}
 
-	input := &reviewInput{
-		ID:        id,
-		Title:     title,
-		Website:   website,
-		Summary:   summary,
-		Rating:    rating,
-		Country:   country,
-		State:     state,
-		Locality:  locality,
-		Email:     email,
-		Phone:     phone,
-		Positives: positives,
-		Negatives: negatives,
-		ExtraInfo: extraInfo,
+	return s.createReview(ctx, input, private)
+}
+
+// createReview validates input and stores the new review, with its private details if there are any
+func (s *ReviewContract) createReview(ctx contractapi.TransactionContextInterface, input *ReviewInput, private *ReviewPrivate) error {
+	id := input.ID
+
+	exists, err := s.ReviewExists(ctx, id)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return apierr.New(apierr.AlreadyExists, "the review %s already exists", id)
 	}
 
 	if err := s.validateInput(input, true); err != nil {
@@ -110,88 +220,269 @@ func (s *ReviewContract) CreateReview(ctx contractapi.TransactionContextInterfac
 		return err
 	}
 
-	reviewJSON, err := json.Marshal(review)
+	if private != nil {
+		if err := putPrivate(ctx, review, private); err != nil {
+			return err
+		}
+	}
+
+	if err := putReview(ctx, review); err != nil {
+		return err
+	}
+
+	if err := recordStats(ctx, nil, review); err != nil {
+		return err
+	}
+	if err := recordCounts(ctx, nil, review); err != nil {
+		return err
+	}
+
+	// the author upvotes their own review
+	if err := recordVote(ctx, id, "", Vote{UserID: review.UserID, Value: Upvote}); err != nil {
+		return err
+	}
+
+	changed, err := changedFields(nil, review)
 	if err != nil {
 		return err
 	}
 
-	return ctx.GetStub().PutState(id, reviewJSON)
+	return emitEvent(ctx, events.ReviewCreated, id, "", review.UserID, changed)
 }
 
-// ReadReview returns the review stored in the world state with given id
+// ReadReview returns the review stored in the world state with given id, along with its votes and comments.
+// Of a deleted or hidden review only the ID, author and the reason it's withheld are returned
 func (s *ReviewContract) ReadReview(ctx contractapi.TransactionContextInterface, id string) (*Review, error) {
-	reviewJSON, err := ctx.GetStub().GetState(id)
+	review, err := s.readReview(ctx, id)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read from world state: %s", err.Error())
+		return nil, err
 	}
-	if reviewJSON == nil {
-		return nil, fmt.Errorf("the review %s does not exist", id)
+
+	if review.Tombstone != nil || review.Hidden != nil {
+		return withheldReview(review), nil
 	}
 
-	var review Review
-	err = json.Unmarshal(reviewJSON, &review)
-	if err != nil {
+	if err := readInteractions(ctx, review); err != nil {
+		return nil, err
+	}
+
+	// the entity may have been registered, or merged, since the review was written
+	if err := linkEntity(ctx, review); err != nil {
 		return nil, err
 	}
 
-	return &review, nil
+	return review, nil
+}
+
+// withheldReview returns what readers see of a deleted or hidden review: its ID, author and why it's withheld
+func withheldReview(review *Review) *Review {
+	return &Review{ID: review.ID, UserID: review.UserID, Tombstone: review.Tombstone, Hidden: review.Hidden}
 }
 
-// UpdateReview updates an existing review in the world state with provided parameters
+// UpdateReview updates an existing review in the world state with provided parameters.
+// It's kept for compatibility, see PatchReview. Like in CreateReview, email and phone are
+// stored in the private collection, but stay readable in the arguments of the transaction
 func (s *ReviewContract) UpdateReview(ctx contractapi.TransactionContextInterface,
 	id string, title, website, summary, country, state, locality, email, phone, positives, negatives, extraInfo string, rating uint8) error {
 
+	input, err := reviewInputFromArgs(id, title, website, summary, country, state, locality, positives, negatives, extraInfo, rating)
+	if err != nil {
+		return err
+	}
+
+	return s.patchReview(ctx, ReviewPatch(*input), email, phone)
+}
+
+// PatchReview changes the non-empty fields of patch in an existing review. Like in CreateReviewV2,
+// private details are passed in the transient map, and replace the stored ones
+func (s *ReviewContract) PatchReview(ctx contractapi.TransactionContextInterface, patch ReviewPatch) error {
+	return s.patchReview(ctx, patch, "", "")
+}
+
+// patchReview applies the patch a client submitted, with the private details passed in the
+// transient map and the contact details passed as arguments to UpdateReview
+func (s *ReviewContract) patchReview(ctx contractapi.TransactionContextInterface, patch ReviewPatch, email, phone string) error {
+	id := patch.ID
+	input := ReviewInput(patch)
+
 	existingReview, err := s.verifyExistsAndOwner(ctx, id)
 	if err != nil {
 		return err
 	}
 
-	input := &reviewInput{
-		ID:        id,
-		Title:     title,
-		Website:   website,
-		Summary:   summary,
-		Rating:    rating,
-		Country:   country,
-		State:     state,
-		Locality:  locality,
-		Email:     email,
-		Phone:     phone,
-		Positives: positives,
-		Negatives: negatives,
-		ExtraInfo: extraInfo,
+	if err := assertNoPrivateExtraInfo(ctx, input.ExtraInfo); err != nil {
+		return err
+	}
+
+	if err := s.validateInput(&input, false); err != nil {
+		return err
 	}
 
-	if err := s.validateInput(input, false); err != nil {
+	private, err := readPrivateInput(ctx, id)
+	if err != nil {
+		return err
+	}
+	updatedReview, err := s.buildReviewFromInput(ctx, &input, existingReview)
+	if err != nil {
 		return err
 	}
 
-	updatedReview, err := s.buildReviewFromInput(ctx, input, existingReview)
+	// contact details stored publicly by earlier versions move to the collection, unless replaced
+	if private != nil {
+		if private, err = withContact(ctx, id, private, email, phone); err == nil {
+			err = putPrivate(ctx, updatedReview, private)
+		}
+	} else {
+		err = mergePrivate(ctx, updatedReview, contactPatch(existingReview.Email, existingReview.Phone), contactPatch(email, phone))
+	}
 	if err != nil {
 		return err
 	}
 
-	reviewJSON, err := json.Marshal(updatedReview)
+	if err := putReview(ctx, updatedReview); err != nil {
+		return err
+	}
+
+	if err := recordStats(ctx, existingReview, updatedReview); err != nil {
+		return err
+	}
+	if err := recordCounts(ctx, existingReview, updatedReview); err != nil {
+		return err
+	}
+
+	changed, err := changedFields(existingReview, updatedReview)
 	if err != nil {
 		return err
 	}
 
-	return ctx.GetStub().PutState(id, reviewJSON)
+	return emitEvent(ctx, events.ReviewUpdated, id, "", updatedReview.UserID, changed)
 }
 
-// DeleteReview deletes a given review from the world state.
+// DeleteReview marks a given review as deleted. It's hidden from listings and queries,
+// and can be restored by its author within the configured restore window. See DeleteReviewV2 to give a reason
 func (s *ReviewContract) DeleteReview(ctx contractapi.TransactionContextInterface, id string) error {
-	if _, err := s.verifyExistsAndOwner(ctx, id); err != nil {
+	return s.DeleteReviewV2(ctx, id, "")
+}
+
+// DeleteReviewV2 deletes a review like DeleteReview, recording why in its tombstone. reason may be empty
+func (s *ReviewContract) DeleteReviewV2(ctx contractapi.TransactionContextInterface, id, reason string) error {
+	existingReview, err := s.verifyExistsAndOwner(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if reason != "" {
+		if err := validateText("reason", reason, noteRule); err != nil {
+			return err
+		}
+	}
+
+	deletedAt, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	before := *existingReview
+	existingReview.Tombstone = &Tombstone{
+		DeletedAt: deletedAt,
+		DeletedBy: existingReview.UserID,
+		Reason:    reason,
+	}
+
+	if err := putReview(ctx, existingReview); err != nil {
+		return err
+	}
+
+	if err := recordStats(ctx, &before, existingReview); err != nil {
 		return err
 	}
-	return ctx.GetStub().DelState(id)
+
+	return emitEvent(ctx, events.ReviewDeleted, id, "", existingReview.UserID, []string{"tombstone"})
+}
+
+// RestoreReview undoes DeleteReview. Only the author can restore a review, and only within the restore window
+func (s *ReviewContract) RestoreReview(ctx contractapi.TransactionContextInterface, id string) error {
+	existingReview, err := s.readReview(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if err := s.verifyOwner(ctx, existingReview); err != nil {
+		return err
+	}
+
+	if existingReview.Tombstone == nil {
+		return apierr.New(apierr.Conflict, "the review %s isn't deleted", id)
+	}
+
+	expired, err := restoreWindowExpired(ctx, existingReview.Tombstone)
+	if err != nil {
+		return err
+	}
+	if expired {
+		return apierr.New(apierr.Conflict, "the review %s can no longer be restored", id)
+	}
+
+	before := *existingReview
+	existingReview.Tombstone = nil
+
+	if err := putReview(ctx, existingReview); err != nil {
+		return err
+	}
+
+	if err := recordStats(ctx, &before, existingReview); err != nil {
+		return err
+	}
+
+	return emitEvent(ctx, events.ReviewRestored, id, "", existingReview.UserID, []string{"tombstone"})
+}
+
+// PurgeReview removes a deleted review, along with its comments and votes, from the world state
+// once its restore window has passed. Only admins may purge reviews
+func (s *ReviewContract) PurgeReview(ctx contractapi.TransactionContextInterface, id string) error {
+	existingReview, err := s.readReview(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if existingReview.Tombstone == nil {
+		return apierr.New(apierr.Conflict, "the review %s isn't deleted", id)
+	}
+
+	expired, err := restoreWindowExpired(ctx, existingReview.Tombstone)
+	if err != nil {
+		return err
+	}
+	if !expired {
+		return apierr.New(apierr.Conflict, "the review %s can still be restored by its author", id)
+	}
+
+	if err := deleteInteractions(ctx, id); err != nil {
+		return fmt.Errorf("failed to delete comments and votes: %v", err)
+	}
+	if existingReview.PrivateCollection != "" {
+		if err := ctx.GetStub().PurgePrivateData(existingReview.PrivateCollection, id); err != nil {
+			return fmt.Errorf("failed to purge private details: %v", err)
+		}
+	}
+	if err := deleteReviewState(ctx, id); err != nil {
+		return err
+	}
+	if err := recordCounts(ctx, existingReview, nil); err != nil {
+		return err
+	}
+
+	admin, err := s.userID(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get user identity: %v", err)
+	}
+
+	return emitEvent(ctx, events.ReviewPurged, id, "", admin, nil)
 }
 
 // ReadAllReviews returns all reviews found in world state
 func (s *ReviewContract) ReadAllReviews(ctx contractapi.TransactionContextInterface) ([]QueryResult, error) {
-	// range query with empty string for startKey and endKey does
-	// an open-ended query of all reviews in the chaincode namespace.
-	resultsIterator, err := ctx.GetStub().GetStateByRange("", "")
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(reviewObjectType, []string{})
 
 	if err != nil {
 		return nil, err
@@ -203,217 +494,257 @@ func (s *ReviewContract) ReadAllReviews(ctx contractapi.TransactionContextInterf
 		}
 	}()
 
-	var results []QueryResult
-
-	for resultsIterator.HasNext() {
-		queryResponse, err := resultsIterator.Next()
-
-		if err != nil {
-			return nil, err
-		}
-
-		var review Review
-		err = json.Unmarshal(queryResponse.Value, &review)
-		if err != nil {
-			return nil, err
-		}
+	return constructQueryResultFromIterator(ctx, resultsIterator, true)
+}
 
-		queryResult := QueryResult{Key: queryResponse.Key, Record: &review}
-		results = append(results, queryResult)
+// ReadReviewsPage returns at most pageSize reviews starting from bookmark.
+// An empty bookmark starts from the first review; the returned bookmark fetches the next page.
+// Deleted and hidden reviews are skipped, so a page may hold fewer reviews, even none, before the last one:
+// only an empty bookmark ends the listing.
+// Votes and comments are left out unless includeInteractions is true, which keeps list views small.
+func (s *ReviewContract) ReadReviewsPage(ctx contractapi.TransactionContextInterface, pageSize int32, bookmark string, includeInteractions bool) (*PaginatedQueryResult, error) {
+	if pageSize < 1 || pageSize > maxPageSize {
+		return nil, apierr.New(apierr.InvalidArgument, "page size must be between 1 and %d", maxPageSize)
 	}
 
-	return results, nil
-}
-
-// CountReviews helps determine if the ledger is already populated
-func (s *ReviewContract) CountReviews(ctx contractapi.TransactionContextInterface) (int, error) {
-	resultsIterator, err := ctx.GetStub().GetStateByRange("", "")
+	resultsIterator, responseMetadata, err := ctx.GetStub().GetStateByPartialCompositeKeyWithPagination(reviewObjectType, []string{}, pageSize, bookmark)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
+
 	defer func() {
 		if closeErr := resultsIterator.Close(); closeErr != nil {
 			err = closeErr
 		}
 	}()
 
-	count := 0
-	for resultsIterator.HasNext() {
-		_, err := resultsIterator.Next()
-		if err != nil {
-			return 0, err
-		}
-		count++
+	results, err := constructQueryResultFromIterator(ctx, resultsIterator, includeInteractions)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PaginatedQueryResult{
+		Records:      results,
+		FetchedCount: int32(len(results)),
+		Bookmark:     responseMetadata.Bookmark,
+	}, nil
+}
+
+// CountReviews returns the number of stored reviews, deleted ones included until purged, from the counters.
+// Reviews written by earlier versions aren't counted until an admin runs RecountAll
+func (s *ReviewContract) CountReviews(ctx contractapi.TransactionContextInterface) (int, error) {
+	counts, _, err := readCounts(ctx, totalDimension)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read counts: %v", err)
 	}
 
-	return count, nil
+	return counts[counter{totalDimension, allReviews}], nil
 }
 
 // AddComment adds a new comment to an existing review
 func (s *ReviewContract) AddComment(ctx contractapi.TransactionContextInterface, reviewID, commentID, commentText string) error {
+	if err := checkIDTime(ctx, "commentID", commentID); err != nil {
+		return err
+	}
+
+	return s.addComment(ctx, reviewID, "", commentID, commentText)
+}
+
+// addComment adds a comment, or a reply to parentID, without checking the time of commentID, which sample comments predate
+func (s *ReviewContract) addComment(ctx contractapi.TransactionContextInterface, reviewID, parentID, commentID, commentText string) error {
 	_, err := ulid.ParseStrict(reviewID)
 	if err != nil {
-		return fmt.Errorf("reviewID isn't ULID %w", err)
+		return apierr.New(apierr.InvalidArgument, "reviewID isn't ULID %v", err)
 	}
 
 	_, err = ulid.ParseStrict(commentID)
 	if err != nil {
-		return fmt.Errorf("commentID isn't ULID %w", err)
+		return apierr.New(apierr.InvalidArgument, "commentID isn't ULID %v", err)
 	}
 
-	userCN, err := s.commonName(ctx)
+	userID, err := s.authorID(ctx, reviewID)
 	if err != nil {
-		return fmt.Errorf("failed to get user identity: %v", err)
+		return err
 	}
 
-	if err := validateStringLength(commentText, 4096); err != nil {
+	if err := validateText("comment", commentText, commentRule); err != nil {
 		return err
 	}
 
-	newComment := Comment{
-		ID:      commentID,
-		UserID:  userCN,
-		Comment: commentText,
-		Votes:   []Vote{{UserID: userCN, Value: 1}},
+	if _, err := s.readActiveReview(ctx, reviewID); err != nil {
+		return err
 	}
 
-	review, err := s.ReadReview(ctx, reviewID)
+	existingComment, err := readComment(ctx, reviewID, commentID)
 	if err != nil {
-		return fmt.Errorf("the review %s does not exist. %w", reviewID, err)
+		return err
+	}
+	if existingComment != nil {
+		return apierr.New(apierr.AlreadyExists, "comment with ID %s already exists", commentID)
 	}
 
-	for _, existingComment := range review.Comments {
-		if existingComment.ID == commentID {
-			return fmt.Errorf("comment with ID %s already exists", commentID)
+	depth := 0
+	if parentID != "" {
+		parent, err := readParent(ctx, reviewID, parentID)
+		if err != nil {
+			return err
 		}
+		depth = parent.Depth + 1
 	}
 
-	review.Comments = append(review.Comments, newComment)
-
-	reviewJSON, err := json.Marshal(review)
+	now, err := txTime(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to marshal review: %v", err)
+		return err
 	}
 
-	err = ctx.GetStub().PutState(reviewID, reviewJSON)
-	if err != nil {
-		return fmt.Errorf("failed to update review state: %v", err)
+	newComment := Comment{
+		ID:        commentID,
+		UserID:    userID,
+		Comment:   commentText,
+		ParentID:  parentID,
+		Depth:     depth,
+		CreatedAt: now,
+		UpdatedAt: now,
+		Revision:  1,
 	}
 
-	return nil
+	if err := putComment(ctx, reviewID, newComment); err != nil {
+		return fmt.Errorf("failed to add comment: %v", err)
+	}
+
+	// the author upvotes their own comment
+	if err := recordVote(ctx, reviewID, commentID, Vote{UserID: userID, Value: Upvote}); err != nil {
+		return err
+	}
+
+	return emitEvent(ctx, events.CommentAdded, reviewID, commentID, userID, nil)
 }
 
 // EditComment allows a user to edit their own comment on a review
 func (s *ReviewContract) EditComment(ctx contractapi.TransactionContextInterface, reviewID, commentID, newCommentText string) error {
 	_, err := ulid.ParseStrict(reviewID)
 	if err != nil {
-		return fmt.Errorf("reviewID isn't ULID %w", err)
+		return apierr.New(apierr.InvalidArgument, "reviewID isn't ULID %v", err)
 	}
 
 	_, err = ulid.ParseStrict(commentID)
 	if err != nil {
-		return fmt.Errorf("commentID isn't ULID %w", err)
+		return apierr.New(apierr.InvalidArgument, "commentID isn't ULID %v", err)
 	}
 
-	if err := validateStringLength(newCommentText, 4096); err != nil {
+	if err := validateText("comment", newCommentText, commentRule); err != nil {
 		return err
 	}
 
-	userCN, err := s.commonName(ctx)
+	userID, err := s.authorID(ctx, reviewID)
 	if err != nil {
-		return fmt.Errorf("failed to get user identity: %v", err)
+		return err
+	}
+
+	if _, err := s.readActiveReview(ctx, reviewID); err != nil {
+		return err
 	}
 
-	review, err := s.ReadReview(ctx, reviewID)
+	comment, err := readComment(ctx, reviewID, commentID)
 	if err != nil {
-		return fmt.Errorf("the review %s does not exist. %w", reviewID, err)
+		return err
+	}
+	if comment == nil {
+		return apierr.New(apierr.NotFound, "comment with ID %s not found in review %s", commentID, reviewID)
 	}
 
-	commentFound := false
-	for i, existingComment := range review.Comments {
-		if existingComment.ID == commentID {
-			// Check if the current user is the author of the comment
-			if existingComment.UserID != userCN {
-				return fmt.Errorf("only the comment author can edit the comment")
-			}
-			// Update the comment text
-			review.Comments[i].Comment = newCommentText
-			commentFound = true
-			break
-		}
+	// Check if the current user is the author of the comment
+	if comment.UserID != userID {
+		return apierr.New(apierr.Forbidden, "only the comment author can edit the comment")
 	}
 
-	if !commentFound {
-		return fmt.Errorf("comment with ID %s not found in review %s", commentID, reviewID)
+	if comment.Hidden != nil {
+		return apierr.New(apierr.Conflict, "comment with ID %s is hidden by moderation", commentID)
 	}
 
-	reviewJSON, err := json.Marshal(review)
+	now, err := txTime(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to marshal review: %v", err)
+		return err
 	}
 
-	err = ctx.GetStub().PutState(reviewID, reviewJSON)
-	if err != nil {
-		return fmt.Errorf("failed to update review state: %v", err)
+	comment.Comment = newCommentText
+	comment.UpdatedAt = now
+	comment.Edited = true
+	comment.Revision = max(comment.Revision, 1) + 1
+
+	if err := putComment(ctx, reviewID, *comment); err != nil {
+		return fmt.Errorf("failed to update comment state: %v", err)
 	}
 
-	return nil
+	return emitEvent(ctx, events.CommentEdited, reviewID, commentID, userID, []string{"comment"})
 }
 
 // DeleteComment allows a user to delete their own comment from a review
 func (s *ReviewContract) DeleteComment(ctx contractapi.TransactionContextInterface, reviewID, commentID string) error {
 	_, err := ulid.ParseStrict(reviewID)
 	if err != nil {
-		return fmt.Errorf("reviewID isn't ULID %w", err)
+		return apierr.New(apierr.InvalidArgument, "reviewID isn't ULID %v", err)
 	}
 	_, err = ulid.ParseStrict(commentID)
 	if err != nil {
-		return fmt.Errorf("commentID isn't ULID %w", err)
+		return apierr.New(apierr.InvalidArgument, "commentID isn't ULID %v", err)
 	}
 
-	userCN, err := s.commonName(ctx)
+	userID, err := s.authorID(ctx, reviewID)
 	if err != nil {
-		return fmt.Errorf("failed to get user identity: %v", err)
+		return err
 	}
 
-	review, err := s.ReadReview(ctx, reviewID)
+	comment, err := readComment(ctx, reviewID, commentID)
 	if err != nil {
-		return fmt.Errorf("the review %s does not exist. %w", reviewID, err)
+		return err
 	}
-
-	// Find the comment and verify ownership
-	commentIndex := -1
-	for i, existingComment := range review.Comments {
-		if existingComment.ID == commentID {
-			// Check if the current user is the author of the comment
-			if existingComment.UserID != userCN {
-				return fmt.Errorf("only the comment author can delete the comment")
-			}
-			commentIndex = i
-			break
-		}
+	if comment == nil {
+		return apierr.New(apierr.NotFound, "comment with ID %s not found in review %s", commentID, reviewID)
 	}
 
-	// Return error if comment not found
-	if commentIndex == -1 {
-		return fmt.Errorf("comment with ID %s not found in review %s", commentID, reviewID)
+	// Check if the current user is the author of the comment
+	if comment.UserID != userID {
+		return apierr.New(apierr.Forbidden, "only the comment author can delete the comment")
 	}
 
-	// Remove the comment using slice manipulation. This efficiently removes the comment at commentIndex without preserving order
-	review.Comments[commentIndex] = review.Comments[len(review.Comments)-1]
-	review.Comments = review.Comments[:len(review.Comments)-1]
-
-	reviewJSON, err := json.Marshal(review)
+	replied, err := hasReplies(ctx, reviewID, commentID, "")
 	if err != nil {
-		return fmt.Errorf("failed to marshal review: %v", err)
+		return err
+	}
+	if replied {
+		// the replies stay in the thread, under a placeholder
+		now, err := txTime(ctx)
+		if err != nil {
+			return err
+		}
+		comment.Comment = deletedPlaceholder
+		comment.UserID = ""
+		comment.Deleted = true
+		comment.UpdatedAt = now
+		comment.Revision = max(comment.Revision, 1) + 1
+		if err := putComment(ctx, reviewID, *comment); err != nil {
+			return fmt.Errorf("failed to update comment state: %v", err)
+		}
+	} else {
+		key, err := commentKey(ctx, reviewID, commentID)
+		if err != nil {
+			return err
+		}
+		if err := ctx.GetStub().DelState(key); err != nil {
+			return fmt.Errorf("failed to delete comment: %v", err)
+		}
+		if err := pruneDeleted(ctx, reviewID, comment.ParentID, commentID); err != nil {
+			return err
+		}
 	}
 
-	err = ctx.GetStub().PutState(reviewID, reviewJSON)
-	if err != nil {
-		return fmt.Errorf("failed to update review state: %v", err)
+	// votes and flags on the comment go with it
+	if err := deleteCommentInteractions(ctx, reviewID, commentID); err != nil {
+		return fmt.Errorf("failed to delete comment votes: %v", err)
 	}
 
-	return nil
+	return emitEvent(ctx, events.CommentDeleted, reviewID, commentID, userID, nil)
 }
 
 // Vote allows a user to vote on a review or a comment within a review
@@ -422,157 +753,80 @@ func (s *ReviewContract) DeleteComment(ctx contractapi.TransactionContextInterfa
 func (s *ReviewContract) Vote(ctx contractapi.TransactionContextInterface, reviewID string, value int8, commentID string) error {
 	_, err := ulid.ParseStrict(reviewID)
 	if err != nil {
-		return fmt.Errorf("reviewID isn't ULID %w", err)
+		return apierr.New(apierr.InvalidArgument, "reviewID isn't ULID %v", err)
 	}
 
 	if value < -1 || value > 1 {
-		return fmt.Errorf("invalid vote value: must be -1, 0, or 1")
+		return apierr.New(apierr.InvalidArgument, "invalid vote value: must be -1, 0, or 1")
 	}
 
-	userCN, err := s.commonName(ctx)
+	userID, err := s.authorID(ctx, reviewID)
 	if err != nil {
-		return fmt.Errorf("failed to get user identity: %v", err)
+		return err
 	}
 
-	review, err := s.ReadReview(ctx, reviewID)
-	if err != nil {
-		return fmt.Errorf("the review %s does not exist. %w", reviewID, err)
+	if _, err := s.readActiveReview(ctx, reviewID); err != nil {
+		return err
 	}
 
 	// Determine if we're voting on a review or a comment
-	isCommentVote := commentID != ""
-
-	if isCommentVote {
+	if commentID != "" {
 		_, err := ulid.ParseStrict(commentID)
 		if err != nil {
-			return fmt.Errorf("commentID isn't ULID %w", err)
+			return apierr.New(apierr.InvalidArgument, "commentID isn't ULID %v", err)
 		}
 
-		// Find the comment
-		commentFound := false
-		for i, comment := range review.Comments {
-			if comment.ID == commentID {
-				commentFound = true
-
-				// Check if user already voted on this comment
-				voteIndex := -1
-				for j, vote := range comment.Votes {
-					if vote.UserID == userCN {
-						voteIndex = j
-						break
-					}
-				}
-
-				// Handle the vote based on value
-				if value == 0 && voteIndex != -1 {
-					// Remove the vote
-					review.Comments[i].Votes[voteIndex] = review.Comments[i].Votes[len(comment.Votes)-1]
-					review.Comments[i].Votes = review.Comments[i].Votes[:len(comment.Votes)-1]
-				} else if value != 0 && voteIndex != -1 {
-					// Update the vote
-					review.Comments[i].Votes[voteIndex].Value = VoteType(value)
-				} else if value != 0 && voteIndex == -1 {
-					// Add new vote
-					newVote := Vote{
-						UserID: userCN,
-						Value:  VoteType(value),
-					}
-					review.Comments[i].Votes = append(review.Comments[i].Votes, newVote)
-				}
-
-				break
-			}
+		comment, err := readComment(ctx, reviewID, commentID)
+		if err != nil {
+			return err
 		}
-
-		if !commentFound {
-			return fmt.Errorf("comment with ID %s not found in review %s", commentID, reviewID)
+		if comment == nil {
+			return apierr.New(apierr.NotFound, "comment with ID %s not found in review %s", commentID, reviewID)
 		}
-	} else {
-		// Check if user already voted on this review
-		voteIndex := -1
-		for i, vote := range review.Votes {
-			if vote.UserID == userCN {
-				voteIndex = i
-				break
-			}
+		if comment.Hidden != nil {
+			return apierr.New(apierr.Conflict, "comment with ID %s is hidden by moderation", commentID)
 		}
-
-		// Handle the vote based on value
-		if value == 0 && voteIndex != -1 {
-			// Remove the vote
-			review.Votes[voteIndex] = review.Votes[len(review.Votes)-1]
-			review.Votes = review.Votes[:len(review.Votes)-1]
-		} else if value != 0 && voteIndex != -1 {
-			// Update the vote
-			review.Votes[voteIndex].Value = VoteType(value)
-		} else if value != 0 && voteIndex == -1 {
-			// Add new vote
-			newVote := Vote{
-				UserID: userCN,
-				Value:  VoteType(value),
-			}
-			review.Votes = append(review.Votes, newVote)
+		if comment.Deleted {
+			return apierr.New(apierr.Conflict, "comment with ID %s was deleted", commentID)
 		}
 	}
 
-	// Save the updated review
-	reviewJSON, err := json.Marshal(review)
-	if err != nil {
-		return fmt.Errorf("failed to marshal review: %v", err)
-	}
-
-	err = ctx.GetStub().PutState(reviewID, reviewJSON)
-	if err != nil {
-		return fmt.Errorf("failed to update review state: %v", err)
+	// A user holds at most one vote per review or comment; it's overwritten, or removed when value is 0
+	if err := recordVote(ctx, reviewID, commentID, Vote{UserID: userID, Value: VoteType(value)}); err != nil {
+		return fmt.Errorf("failed to update vote state: %v", err)
 	}
 
-	return nil
+	return emitEvent(ctx, events.Voted, reviewID, commentID, userID, nil)
 }
 
 // InitLedger adds a base set of reviews to the ledger
 func (s *ReviewContract) InitLedger(ctx contractapi.TransactionContextInterface, addSampleReviews bool) error {
-	ReviewCount, err := s.CountReviews(ctx)
+	count, err := storedReviews(ctx, 0)
 	if err != nil {
 		return err
 	}
-	if ReviewCount == 0 && addSampleReviews {
-		fmt.Println("ReviewCount", ReviewCount, "adding Sample Reviews")
-
+	if count == 0 && addSampleReviews {
 		// Store reviews in world state using CreateReview
 		for _, review := range sampleReviews {
-			positivesJSON, err := json.Marshal(review.Positives)
-			if err != nil {
-				log.Println("Failed to marshal Positives", err)
-				return fmt.Errorf("failed to marshal Positives to JSON: %v", err)
+			input := &ReviewInput{
+				ID:        review.ID,
+				Title:     review.Title,
+				Website:   review.Website,
+				Summary:   review.Summary,
+				Rating:    review.Rating,
+				Country:   review.Country,
+				State:     review.State,
+				Locality:  review.Locality,
+				Positives: review.Positives,
+				Negatives: review.Negatives,
+				ExtraInfo: review.ExtraInfo,
 			}
-
-			negativesJSON, err := json.Marshal(review.Negatives)
-			if err != nil {
-				log.Println("Failed to marshal Negatives", err)
-				return fmt.Errorf("failed to marshal Negatives to JSON: %v", err)
-			}
-
-			extraInfoJSON, err := json.Marshal(review.ExtraInfo)
+			salt, err := privateSalt(ctx, review.ID)
 			if err != nil {
-				log.Println("Failed to marshal ExtraInfo", err)
-				return fmt.Errorf("failed to marshal ExtraInfo to JSON: %v", err)
+				return err
 			}
-			err = s.CreateReview(
-				ctx,
-				review.ID,
-				review.Title,
-				review.Website,
-				review.Summary,
-				review.Country,
-				review.State,
-				review.Locality,
-				review.Email,
-				review.Phone,
-				string(positivesJSON),
-				string(negativesJSON),
-				string(extraInfoJSON),
-				review.Rating,
-			)
+			private := &ReviewPrivate{ID: review.ID, Email: review.Email, Phone: review.Phone, Salt: salt}
+			err = s.createReview(ctx, input, private)
 			if err != nil {
 				log.Println("InitLedger failed", err)
 				return fmt.Errorf("failed to create review: %v", err)
@@ -584,20 +838,19 @@ func (s *ReviewContract) InitLedger(ctx contractapi.TransactionContextInterface,
 }
 
 func (s *ReviewContract) AddSampleComments(ctx contractapi.TransactionContextInterface) error {
-	reviewCounts, err := s.CountReviews(ctx)
+	count, err := storedReviews(ctx, len(sampleReviews))
 	if err != nil {
-		log.Println("Failed to CountReviews", err)
-		return fmt.Errorf("failed to CountReviews: %v", err)
+		log.Println("Failed to count reviews", err)
+		return fmt.Errorf("failed to count reviews: %v", err)
 	}
-	if reviewCounts != 5 {
-		log.Println("expected reviewCounts 5, got", reviewCounts)
-		return fmt.Errorf("expected 5 reviews, got %d", reviewCounts)
+	if count != len(sampleReviews) {
+		log.Println("expected", len(sampleReviews), "reviews, got", count)
+		return apierr.New(apierr.Conflict, "expected the %d sample reviews alone, got %d or more", len(sampleReviews), count)
 	}
 
-	// TODO: FIX - it adds only one comment instead of two
 	for _, reviewComments := range sampleComments {
 		for _, comment := range reviewComments.Comments {
-			err = s.AddComment(ctx, reviewComments.ReviewID, comment.ID, comment.Comment)
+			err = s.addComment(ctx, reviewComments.ReviewID, "", comment.ID, comment.Comment)
 			if err != nil {
 				log.Println("Failed to add comment", err)
 				return fmt.Errorf("failed to add comment: %v", err)
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/edgeflare/fabreview/apierr"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+func TestReadReviewsPage(t *testing.T) {
+	s := newTestStub(t)
+	var ids []string
+	for _, website := range []string{"a.example", "b.example", "c.example"} {
+		ids = append(ids, s.createReview(alice, website))
+	}
+
+	var got []string
+	bookmark := ""
+	for pages := 0; ; pages++ {
+		if pages == len(ids) {
+			t.Fatalf("still paging after %d pages", pages)
+		}
+		var page PaginatedQueryResult
+		s.mustInvokeJSON(&page, reader, "ReadReviewsPage", "2", bookmark, "false")
+		for _, result := range page.Records {
+			got = append(got, result.Record.ID)
+			if len(result.Record.Votes) != 0 {
+				t.Errorf("review %s has votes without includeInteractions", result.Record.ID)
+			}
+		}
+		if page.Bookmark == "" {
+			break
+		}
+		bookmark = page.Bookmark
+	}
+
+	if !slices.Equal(got, ids) {
+		t.Errorf("paged through %v, want %v", got, ids)
+	}
+}
+
+func TestReadReviewsPageIncludesInteractions(t *testing.T) {
+	s := newTestStub(t)
+	id := s.createReview(alice, "example.com")
+
+	var page PaginatedQueryResult
+	s.mustInvokeJSON(&page, reader, "ReadReviewsPage", "10", "", "true")
+	if len(page.Records) != 1 || page.Records[0].Record.ID != id {
+		t.Fatalf("got %+v, want review %s", page.Records, id)
+	}
+	if votes := page.Records[0].Record.Votes; len(votes) != 1 || votes[0].Value != Upvote {
+		t.Errorf("got votes %+v, want the author's upvote", votes)
+	}
+}
+
+func TestReadReviewsPageSize(t *testing.T) {
+	s := newTestStub(t)
+	for _, pageSize := range []string{"0", "-1", itoa(maxPageSize + 1)} {
+		s.wantError(apierr.InvalidArgument, reader, "ReadReviewsPage", pageSize, "", "false")
+	}
+}
+
+func TestDeleteReview(t *testing.T) {
+	s := newTestStub(t)
+	id := s.createReview(alice, "example.com")
+
+	s.wantError(apierr.Forbidden, bob, "DeleteReview", id)
+	s.mustInvoke(alice, "DeleteReview", id)
+
+	review := s.readReview(id)
+	if review.Tombstone == nil || review.Title != "" || review.Tombstone.DeletedBy != review.UserID || review.Tombstone.Reason != "" {
+		t.Errorf("got %+v, want a redacted review with a tombstone", review)
+	}
+	var page PaginatedQueryResult
+	s.mustInvokeJSON(&page, reader, "ReadReviewsPage", "10", "", "false")
+	if len(page.Records) != 0 || page.FetchedCount != 0 {
+		t.Errorf("deleted review listed: %+v", page)
+	}
+	s.wantError(apierr.NotFound, alice, "PatchReview", toJSON(t, ReviewPatch{ID: id, Title: "Edited after deletion"}))
+
+	s.mustInvoke(alice, "RestoreReview", id)
+	if review := s.readReview(id); review.Tombstone != nil || review.Title == "" {
+		t.Errorf("got %+v after restoring", review)
+	}
+	s.wantError(apierr.Conflict, alice, "RestoreReview", id)
+}
+
+func TestDeleteReviewV2(t *testing.T) {
+	s := newTestStub(t)
+	id := s.createReview(alice, "example.com")
+
+	s.wantError(apierr.InvalidArgument, alice, "DeleteReviewV2", id, strings.Repeat("x", 1000))
+	s.mustInvoke(alice, "DeleteReviewV2", id, "Posted on the wrong website")
+	if review := s.readReview(id); review.Tombstone == nil || review.Tombstone.Reason != "Posted on the wrong website" {
+		t.Errorf("got tombstone %+v", review.Tombstone)
+	}
+}
+
+func TestPurgeReview(t *testing.T) {
+	s := newTestStub(t)
+	id := s.createReview(alice, "example.com")
+	s.mustInvoke(bob, "AddComment", id, s.newID(), "Agreed.")
+
+	s.wantError(apierr.Conflict, admin, "PurgeReview", id)
+	s.mustInvoke(alice, "DeleteReview", id)
+	s.wantError(apierr.Conflict, admin, "PurgeReview", id)
+
+	s.advance(time.Duration(defaultConfig().RestoreWindowHours+1) * time.Hour)
+	s.wantError(apierr.Conflict, alice, "RestoreReview", id)
+	s.wantError(apierr.Forbidden, alice, "PurgeReview", id)
+	s.mustInvoke(admin, "PurgeReview", id)
+
+	s.wantError(apierr.NotFound, reader, "ReadReview", id)
+	for _, objectType := range []string{commentObjectType, voteObjectType, voteDeltaObjectType} {
+		if n := s.countKeys(objectType, id); n != 0 {
+			t.Errorf("%d %s keys left after purging", n, objectType)
+		}
+	}
+	var count int
+	s.mustInvokeJSON(&count, reader, "CountReviews")
+	if count != 0 {
+		t.Errorf("got %d reviews after purging", count)
+	}
+}
+
+func TestPagesSkipDeletedReviews(t *testing.T) {
+	s := newTestStub(t)
+	first := s.createReview(alice, "a.example")
+	second := s.createReview(alice, "b.example")
+	third := s.createReview(alice, "c.example")
+	s.mustInvoke(alice, "DeleteReview", second)
+
+	var page PaginatedQueryResult
+	s.mustInvokeJSON(&page, reader, "ReadReviewsPage", "2", "", "false")
+	if len(page.Records) != 1 || page.Records[0].Record.ID != first || page.FetchedCount != 1 || page.Bookmark == "" {
+		t.Fatalf("got %+v, want a short first page and a bookmark", page)
+	}
+	s.mustInvokeJSON(&page, reader, "ReadReviewsPage", "2", page.Bookmark, "false")
+	if len(page.Records) != 1 || page.Records[0].Record.ID != third || page.FetchedCount != 1 {
+		t.Errorf("got %+v, want the third review", page)
+	}
+}
+
+func TestCreateReviewPositional(t *testing.T) {
+	s := newTestStub(t)
+	id := s.newID()
+	s.mustInvoke(alice, "CreateReview", id, "Positional", "example.com", "A summary long enough to pass validation.", "BD", "Dhaka", "Mirpur",
+		NOT_SUPPLIED, "", `["pay"]`, `["hours"]`, `{"team":"QA"}`, "8")
+
+	review := s.readReview(id)
+	if review.Title != "Positional" || review.Rating != 8 || review.Locality != "Mirpur" ||
+		!slices.Equal(review.Positives, []string{"pay"}) || review.ExtraInfo["team"] != "QA" {
+		t.Errorf("got %+v", review)
+	}
+
+	s.mustInvoke(alice, "UpdateReview", id, "Renamed", "", "", "", "", "", "", "", "", "", "", "0")
+	if review := s.readReview(id); review.Title != "Renamed" || review.Rating != 8 || !slices.Equal(review.Negatives, []string{"hours"}) {
+		t.Errorf("got %+v, want only the title changed", review)
+	}
+
+	// contact details go to the collection
+	id = s.newID()
+	s.mustInvoke(alice, "CreateReview", id, "Contact", "example.com", "A summary long enough to pass validation.", "BD", "", "",
+		"hr@example.com", "", "", "", "", "8")
+	txID := fmt.Sprintf("%064x", s.txCount)
+	s.mustInvoke(alice, "UpdateReview", id, "", "", "", "", "", "", "", "+8801712345678", "", "", "", "0")
+	if review := s.readReview(id); review.Email != "" || review.Phone != "" || review.PrivateCollection == "" {
+		t.Errorf("got %+v, want the contact details kept private", review)
+	}
+	var private ReviewPrivate
+	s.mustInvokeJSON(&private, reader, "ReadReviewPrivate", id)
+	if private.Email != "hr@example.com" || private.Phone != "+8801712345678" || len(private.Salt) < minSaltLength || private.Salt == txID {
+		t.Errorf("got %+v", private)
+	}
+
+	s.wantError(apierr.InvalidArgument, alice, "CreateReview", s.newID(), "Bad email", "example.com", "A summary long enough to pass validation.", "BD", "", "",
+		"not an email", "", "", "", "", "8")
+	s.wantError(apierr.InvalidArgument, alice, "CreateReview", s.newID(), "Bad positives", "example.com", "A summary long enough to pass validation.", "BD", "", "",
+		"", "", "pay", "", "", "8")
+}
+
+func TestCreateReviewV2(t *testing.T) {
+	s := newTestStub(t)
+	input := s.reviewInput("example.com")
+	input.Positives = []string{"pay"}
+	s.mustInvoke(alice, "CreateReviewV2", toJSON(t, input))
+
+	if review := s.readReview(input.ID); review.Title != input.Title || !slices.Equal(review.Positives, input.Positives) {
+		t.Errorf("got %+v", review)
+	}
+	s.wantError(apierr.AlreadyExists, alice, "CreateReviewV2", toJSON(t, input))
+
+	// contractapi checks the argument against the metadata schema
+	if response := s.invoke(alice, "CreateReviewV2", `{"id":"`+s.newID()+`","title":"No rating"}`); response.Status == shim.OK {
+		t.Error("accepted a review without the required fields")
+	}
+	if response := s.invoke(alice, "CreateReviewV2", `{"id":"`+s.newID()+`","rating":"seven"}`); response.Status == shim.OK {
+		t.Error("accepted a string rating")
+	}
+}
+
+func TestInitLedger(t *testing.T) {
+	s := newTestStub(t)
+	s.mustInvoke(admin, "InitLedger", "true")
+
+	var count int
+	s.mustInvokeJSON(&count, reader, "CountReviews")
+	if count != len(sampleReviews) {
+		t.Errorf("got %d reviews, want the %d samples", count, len(sampleReviews))
+	}
+
+	s.mustInvoke(admin, "AddSampleComments")
+	if n := s.countKeys(commentObjectType); n == 0 {
+		t.Error("no sample comments")
+	}
+	s.mustInvoke(admin, "InitLedger", "true")
+	s.mustInvokeJSON(&count, reader, "CountReviews")
+	if count != len(sampleReviews) {
+		t.Errorf("got %d reviews after initializing twice", count)
+	}
+}
+
+func TestInitLedgerOnPopulatedLedger(t *testing.T) {
+	s := newTestStub(t)
+	s.seedBareReview("example.com") // written before counters, not counted yet
+
+	s.mustInvoke(admin, "InitLedger", "true")
+	if n := s.countKeys(reviewObjectType); n != 0 {
+		t.Errorf("added %d sample reviews to a populated ledger", n)
+	}
+	s.wantError(apierr.Conflict, admin, "AddSampleComments")
+	if n := s.countKeys(commentObjectType); n != 0 {
+		t.Errorf("added %d sample comments to a populated ledger", n)
+	}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"github.com/edgeflare/fabreview/apierr"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Reviews are counted in total, per country and per website like vote tallies: creating or purging a review,
+// or changing its country or website, writes a delta under its own key, so the counters don't conflict, and
+// reading one sums its checkpoint and the deltas written since. Every stored review counts, deleted and
+// hidden ones included, until it's purged. RecountAll replaces the deltas with checkpoints recomputed from the reviews.
+const (
+	countDeltaObjectType = "countdelta" // countdelta~dimension~value~txID~reviewID
+	countObjectType      = "count"      // count~dimension~value
+)
+
+// Dimensions reviews are counted by
+const (
+	totalDimension   = "total" // a single counter, valued allReviews
+	countryDimension = "country"
+	websiteDimension = "website" // canonical domains, like the statistics
+	allReviews       = "all"
+)
+
+// ReviewCount is the number of reviews with one value of a dimension, eg the reviews from BD
+type ReviewCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// counter identifies the count of one value of a dimension
+type counter struct {
+	dimension string
+	value     string
+}
+
+// countDelta is the change a single transaction made to a counter, or its checkpoint
+type countDelta struct {
+	Count int `json:"count"`
+	// DocType and SchemaVersion identify the stored document, see schemaVersion
+	DocType       string `json:"doc_type,omitzero"`
+	SchemaVersion int    `json:"schema_version,omitzero"`
+}
+
+// reviewCounters returns the counters a review counts towards
+func reviewCounters(review *Review) []counter {
+	counters := []counter{{totalDimension, allReviews}, {websiteDimension, statsWebsite(review.Website)}}
+	if review.Country != "" {
+		counters = append(counters, counter{countryDimension, review.Country})
+	}
+	return counters
+}
+
+// compareCounters orders counters by dimension, then value
+func compareCounters(a, b counter) int {
+	return cmp.Or(cmp.Compare(a.dimension, b.dimension), cmp.Compare(a.value, b.value))
+}
+
+// recordCounts writes the deltas turning the counts of before into those of after, which are the same
+// review; either may be nil. The deltas are keyed by review too, as InitLedger creates several in one transaction
+func recordCounts(ctx contractapi.TransactionContextInterface, before, after *Review) error {
+	deltas := map[counter]int{}
+	var id string
+	if before != nil {
+		id = before.ID
+		for _, c := range reviewCounters(before) {
+			deltas[c]--
+		}
+	}
+	if after != nil {
+		id = after.ID
+		for _, c := range reviewCounters(after) {
+			deltas[c]++
+		}
+	}
+
+	// sorted, so every endorser writes the keys in the same order
+	counters := make([]counter, 0, len(deltas))
+	for c, n := range deltas {
+		if n != 0 {
+			counters = append(counters, c)
+		}
+	}
+	slices.SortFunc(counters, compareCounters)
+
+	for _, c := range counters {
+		key, err := ctx.GetStub().CreateCompositeKey(countDeltaObjectType, []string{c.dimension, c.value, ctx.GetStub().GetTxID(), id})
+		if err != nil {
+			return err
+		}
+		deltaJSON, err := json.Marshal(countDelta{Count: deltas[c], DocType: countDeltaObjectType, SchemaVersion: schemaVersion})
+		if err != nil {
+			return fmt.Errorf("failed to marshal count delta: %v", err)
+		}
+		if err := ctx.GetStub().PutState(key, deltaJSON); err != nil {
+			return fmt.Errorf("failed to write count delta: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// sumCounts adds the checkpoints or deltas stored under objectType~attributes to counts, by value.
+// It returns the keys it read
+func sumCounts(ctx contractapi.TransactionContextInterface, counts map[counter]int, objectType string, attributes ...string) ([]string, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(objectType, attributes)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if closeErr := resultsIterator.Close(); closeErr != nil {
+			err = closeErr
+		}
+	}()
+
+	var keys []string
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		keyAttributes, err := splitKey(ctx, queryResponse.Key, objectType)
+		if err != nil {
+			return nil, err
+		}
+		if len(keyAttributes) < 2 {
+			return nil, fmt.Errorf("%q isn't a %s key", queryResponse.Key, objectType)
+		}
+
+		var delta countDelta
+		if err := decodeDocument(objectType, queryResponse.Value, &delta); err != nil {
+			return nil, err
+		}
+		counts[counter{keyAttributes[0], keyAttributes[1]}] += delta.Count
+		keys = append(keys, queryResponse.Key)
+	}
+
+	return keys, nil
+}
+
+// readCounts sums the checkpoints and deltas of the counters of a dimension, or of every dimension
+// when it's empty. It also returns the keys it read
+func readCounts(ctx contractapi.TransactionContextInterface, dimension string) (map[counter]int, []string, error) {
+	var attributes []string
+	if dimension != "" {
+		attributes = []string{dimension}
+	}
+
+	counts := map[counter]int{}
+	checkpointKeys, err := sumCounts(ctx, counts, countObjectType, attributes...)
+	if err != nil {
+		return nil, nil, err
+	}
+	deltaKeys, err := sumCounts(ctx, counts, countDeltaObjectType, attributes...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return counts, append(checkpointKeys, deltaKeys...), nil
+}
+
+// checkDimension refuses dimensions reviews aren't counted by
+func checkDimension(dimension string) error {
+	if !slices.Contains([]string{totalDimension, countryDimension, websiteDimension}, dimension) {
+		return apierr.New(apierr.InvalidArgument, "dimension must be %s, %s or %s", totalDimension, countryDimension, websiteDimension)
+	}
+	return nil
+}
+
+// reviewCounts presents the counts of a dimension, sorted by value. Values without reviews are left out
+func reviewCounts(counts map[counter]int) []ReviewCount {
+	result := make([]ReviewCount, 0, len(counts))
+	for c, n := range counts {
+		if n != 0 {
+			result = append(result, ReviewCount{Value: c.value, Count: n})
+		}
+	}
+	slices.SortFunc(result, func(a, b ReviewCount) int { return cmp.Compare(a.Value, b.Value) })
+	return result
+}
+
+// putCounts stores counts as checkpoints and deletes the stale keys it didn't overwrite.
+// Counters at 0 are deleted rather than stored
+func putCounts(ctx contractapi.TransactionContextInterface, counts map[counter]int, staleKeys []string) error {
+	// sorted, so every endorser writes the keys in the same order
+	counters := make([]counter, 0, len(counts))
+	for c, n := range counts {
+		if n != 0 {
+			counters = append(counters, c)
+		}
+	}
+	slices.SortFunc(counters, compareCounters)
+
+	written := map[string]bool{}
+	for _, c := range counters {
+		key, err := ctx.GetStub().CreateCompositeKey(countObjectType, []string{c.dimension, c.value})
+		if err != nil {
+			return err
+		}
+		checkpointJSON, err := json.Marshal(countDelta{Count: counts[c], DocType: countObjectType, SchemaVersion: schemaVersion})
+		if err != nil {
+			return fmt.Errorf("failed to marshal count: %v", err)
+		}
+		if err := ctx.GetStub().PutState(key, checkpointJSON); err != nil {
+			return fmt.Errorf("failed to write count: %v", err)
+		}
+		written[key] = true
+	}
+
+	for _, key := range staleKeys {
+		if written[key] {
+			continue
+		}
+		if err := ctx.GetStub().DelState(key); err != nil {
+			return fmt.Errorf("failed to delete count: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// GetCounts returns the number of reviews for each value of a dimension: total, country or website.
+// Values without reviews are left out
+func (s *ReviewContract) GetCounts(ctx contractapi.TransactionContextInterface, dimension string) ([]ReviewCount, error) {
+	if err := checkDimension(dimension); err != nil {
+		return nil, err
+	}
+
+	counts, _, err := readCounts(ctx, dimension)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read counts: %v", err)
+	}
+
+	return reviewCounts(counts), nil
+}
+
+// CompactCounts folds the deltas of the counters of a dimension into their checkpoints, and returns the counts
+// like GetCounts. Unlike RecountAll it doesn't read the reviews, so it only conflicts with reviews counted
+// in the dimension meanwhile. Only admins may compact counts.
+func (s *ReviewContract) CompactCounts(ctx contractapi.TransactionContextInterface, dimension string) ([]ReviewCount, error) {
+	if err := checkDimension(dimension); err != nil {
+		return nil, err
+	}
+
+	counts, keys, err := readCounts(ctx, dimension)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read counts: %v", err)
+	}
+	if err := putCounts(ctx, counts, keys); err != nil {
+		return nil, err
+	}
+
+	return reviewCounts(counts), nil
+}
+
+// RecountAll recomputes every counter from the stored reviews, replaces the checkpoints and deltas with them,
+// and returns the total. Reviews still stored under their bare ID are counted too, so it can run before
+// MigrateKeys has moved them. It reads every review, so it conflicts with reviews created meanwhile and must
+// be retried. Only admins may recount reviews.
+func (s *ReviewContract) RecountAll(ctx contractapi.TransactionContextInterface) (int, error) {
+	_, staleKeys, err := readCounts(ctx, "")
+	if err != nil {
+		return 0, fmt.Errorf("failed to read counts: %v", err)
+	}
+
+	counts := map[counter]int{}
+	err = forEachReview(ctx, func(id, key string, value []byte) error {
+		var review Review
+		if err := decodeReview(value, &review); err != nil {
+			return err
+		}
+		for _, c := range reviewCounters(&review) {
+			counts[c]++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if err := putCounts(ctx, counts, staleKeys); err != nil {
+		return 0, err
+	}
+
+	return counts[counter{totalDimension, allReviews}], nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/edgeflare/fabreview/apierr"
+)
+
+// reviewCountsOf returns the counts of a dimension
+func (s *testStub) reviewCountsOf(dimension string) []ReviewCount {
+	s.t.Helper()
+
+	var counts []ReviewCount
+	s.mustInvokeJSON(&counts, reader, "GetCounts", dimension)
+	return counts
+}
+
+func TestGetCounts(t *testing.T) {
+	s := newTestStub(t)
+	first := s.createReview(alice, "https://www.Example.com/")
+	s.createReview(bob, "example.com")
+	s.createReview(alice, "other.example")
+	s.mustInvoke(alice, "PatchReview", toJSON(t, ReviewPatch{ID: first, Country: "US"}))
+	s.mustInvoke(alice, "DeleteReview", first) // still counted until purged
+
+	tests := []struct {
+		dimension string
+		want      []ReviewCount
+	}{
+		{totalDimension, []ReviewCount{{allReviews, 3}}},
+		{countryDimension, []ReviewCount{{"BD", 2}, {"US", 1}}},
+		{websiteDimension, []ReviewCount{{"example.com", 2}, {"other.example", 1}}},
+	}
+	for _, tt := range tests {
+		if got := s.reviewCountsOf(tt.dimension); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("got %s counts %+v, want %+v", tt.dimension, got, tt.want)
+		}
+	}
+	s.wantError(apierr.InvalidArgument, reader, "GetCounts", "city")
+
+	var count int
+	s.mustInvokeJSON(&count, reader, "CountReviews")
+	if count != 3 {
+		t.Errorf("got %d reviews, want 3", count)
+	}
+}
+
+func TestCompactCounts(t *testing.T) {
+	s := newTestStub(t)
+	s.mustInvoke(alice, "PatchReview", toJSON(t, ReviewPatch{ID: s.createReview(alice, "example.com"), Country: "US"}))
+	s.mustInvoke(bob, "PatchReview", toJSON(t, ReviewPatch{ID: s.createReview(bob, "other.example"), Country: "US"}))
+	want := s.reviewCountsOf(countryDimension)
+
+	s.wantError(apierr.Forbidden, alice, "CompactCounts", countryDimension)
+	s.wantError(apierr.InvalidArgument, admin, "CompactCounts", "")
+
+	var compacted []ReviewCount
+	s.mustInvokeJSON(&compacted, admin, "CompactCounts", countryDimension)
+	if !reflect.DeepEqual(compacted, want) {
+		t.Errorf("compacted into %+v, want %+v", compacted, want)
+	}
+	if got := s.reviewCountsOf(countryDimension); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v after compaction, want %+v", got, want)
+	}
+	if n := s.countKeys(countDeltaObjectType, countryDimension); n != 0 {
+		t.Errorf("%d country deltas left after compaction", n)
+	}
+	if n := s.countKeys(countDeltaObjectType, websiteDimension); n == 0 {
+		t.Error("compacting countries compacted websites")
+	}
+	// BD has no reviews left, its counter isn't kept
+	if n := s.countKeys(countObjectType, countryDimension, "BD"); n != 0 {
+		t.Errorf("got %d checkpoints of an empty counter", n)
+	}
+
+	// deltas written after compaction add to the checkpoints
+	s.createReview(alice, "example.com")
+	if got := s.reviewCountsOf(countryDimension); !reflect.DeepEqual(got, []ReviewCount{{"BD", 1}, {"US", 2}}) {
+		t.Errorf("got %+v", got)
+	}
+}
+
+func TestRecountAll(t *testing.T) {
+	s := newTestStub(t)
+	s.createReview(alice, "example.com")
+	bare := s.seedBareReview("other.example") // never counted
+
+	var total int
+	s.wantError(apierr.Forbidden, alice, "RecountAll")
+	s.mustInvokeJSON(&total, admin, "RecountAll")
+	if total != 2 {
+		t.Errorf("recounted %d reviews, want the bare one too", total)
+	}
+	if n := s.countKeys(countDeltaObjectType); n != 0 {
+		t.Errorf("%d deltas left after recounting", n)
+	}
+	if got, want := s.reviewCountsOf(websiteDimension), []ReviewCount{{"example.com", 1}, {"other.example", 1}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	// moving the bare review doesn't count it twice
+	s.mustInvoke(admin, "MigrateKeys", "", "10")
+	s.mustInvokeJSON(&total, admin, "RecountAll")
+	if total != 2 || s.readReview(bare).ID != bare {
+		t.Errorf("recounted %d reviews after moving %s", total, bare)
+	}
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/edgeflare/fabreview/apierr"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"golang.org/x/net/idna"
+)
+
+// Reviewed companies and organisations are registered as entities, identified by a canonical domain.
+// Each domain, and each alias, points to its entity, so reviews of any of them link to the same one.
+const (
+	entityObjectType       = "entity"       // entity~entityID
+	entityDomainObjectType = "entitydomain" // entitydomain~domain, the ID of the entity the domain belongs to
+	maxAliases             = 32
+)
+
+// Entity is a reviewed company or organisation
+type Entity struct {
+	ID         string    `json:"id"`     // ULID
+	Domain     string    `json:"domain"` // canonical domain, see canonicalWebsite
+	Name       string    `json:"name"`   // display name, max 128 chars
+	Country    string    `json:"country,omitzero" metadata:",optional"`
+	Aliases    []string  `json:"aliases"`                                   // other canonical domains of the entity
+	MergedInto string    `json:"merged_into,omitzero" metadata:",optional"` // set when merged into another entity
+	CreatedAt  time.Time `json:"created_at"`
+	// DocType and SchemaVersion identify the stored document, see schemaVersion
+	DocType       string `json:"doc_type,omitzero" metadata:",optional"`
+	SchemaVersion int    `json:"schema_version,omitzero" metadata:",optional"`
+}
+
+// EntityInput is the entity RegisterEntity takes
+type EntityInput struct {
+	ID      string   `json:"id"`
+	Domain  string   `json:"domain"`
+	Name    string   `json:"name"`
+	Country string   `json:"country,omitzero" metadata:",optional"`
+	Aliases []string `json:"aliases,omitzero" metadata:",optional"`
+}
+
+// PaginatedEntities is a page of ListEntities
+type PaginatedEntities struct {
+	Records      []*Entity `json:"records"`
+	FetchedCount int32     `json:"fetched_count"`
+	Bookmark     string    `json:"bookmark"` // opaque, pass back to fetch the next page
+}
+
+// canonicalWebsite reduces a website to the domain identifying who it belongs to: lowercase, in ASCII,
+// without scheme, credentials, port, path, trailing dot or leading www., eg https://www.TechnoBD.com:443/about
+// becomes technobd.com
+func canonicalWebsite(website string) (string, error) {
+	domain := strings.TrimSpace(website)
+	if i := strings.Index(domain, "://"); i >= 0 {
+		domain = domain[i+len("://"):]
+	}
+	if i := strings.IndexAny(domain, "/?#"); i >= 0 {
+		domain = domain[:i]
+	}
+	if i := strings.LastIndex(domain, "@"); i >= 0 {
+		domain = domain[i+1:]
+	}
+	if i := strings.LastIndex(domain, ":"); i >= 0 {
+		domain = domain[:i]
+	}
+	domain = strings.TrimSuffix(domain, ".")
+
+	domain, err := idna.Lookup.ToASCII(domain)
+	if err != nil {
+		return "", fmt.Errorf("%q isn't a domain name: %v", website, err)
+	}
+	domain = strings.TrimPrefix(domain, "www.")
+	if !strings.Contains(domain, ".") {
+		return "", fmt.Errorf("%q isn't a domain name", website)
+	}
+	if len(domain) > 64 {
+		return "", fmt.Errorf("domain exceeds 64 chars")
+	}
+
+	return domain, nil
+}
+
+// readEntity returns the entity stored with id
+func readEntity(ctx contractapi.TransactionContextInterface, id string) (*Entity, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(entityObjectType, []string{id})
+	if err != nil {
+		return nil, err
+	}
+	entityJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if entityJSON == nil {
+		return nil, apierr.New(apierr.NotFound, "the entity %s does not exist", id)
+	}
+
+	var entity Entity
+	if err := decodeDocument(entityObjectType, entityJSON, &entity); err != nil {
+		return nil, err
+	}
+
+	return &entity, nil
+}
+
+// putEntity stores an entity
+func putEntity(ctx contractapi.TransactionContextInterface, entity *Entity) error {
+	key, err := ctx.GetStub().CreateCompositeKey(entityObjectType, []string{entity.ID})
+	if err != nil {
+		return err
+	}
+	entity.DocType, entity.SchemaVersion = entityObjectType, schemaVersion
+	entityJSON, err := json.Marshal(entity)
+	if err != nil {
+		return fmt.Errorf("failed to marshal entity: %v", err)
+	}
+	return ctx.GetStub().PutState(key, entityJSON)
+}
+
+// domainKey returns the key holding the ID of the entity a domain belongs to
+func domainKey(ctx contractapi.TransactionContextInterface, domain string) (string, error) {
+	return ctx.GetStub().CreateCompositeKey(entityDomainObjectType, []string{domain})
+}
+
+// entityIDOf returns the ID of the entity a canonical domain belongs to, empty if it's not registered
+func entityIDOf(ctx contractapi.TransactionContextInterface, domain string) (string, error) {
+	key, err := domainKey(ctx, domain)
+	if err != nil {
+		return "", err
+	}
+	id, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return "", fmt.Errorf("failed to read from world state: %v", err)
+	}
+	return string(id), nil
+}
+
+// linkEntity sets the entity of a review from its website. Reviews written before the entity was
+// registered are left unlinked until they're next written, or read, see ReadReview
+func linkEntity(ctx contractapi.TransactionContextInterface, review *Review) error {
+	if review.Website == "" {
+		return nil
+	}
+	id, err := entityIDOf(ctx, review.Website)
+	if err != nil {
+		return err
+	}
+	review.EntityID = id
+	return nil
+}
+
+// RegisterEntity registers a company or organisation. Its domain and aliases are canonicalized,
+// and must not belong to another entity
+func (s *ReviewContract) RegisterEntity(ctx contractapi.TransactionContextInterface, input EntityInput) error {
+	if err := checkIDTime(ctx, "id", input.ID); err != nil {
+		return err
+	}
+
+	v := &validator{}
+	v.text("name", input.Name, nameRule)
+	if input.Country != "" {
+		input.Country = strings.ToUpper(input.Country)
+		v.text("country", input.Country, countryRule)
+	}
+	domain, err := canonicalWebsite(input.Domain)
+	if err != nil {
+		v.add("domain", apierr.InvalidFormat, err.Error())
+	}
+	if len(input.Aliases) > maxAliases {
+		v.add("aliases", apierr.TooMany, fmt.Sprintf("must have at most %d items", maxAliases))
+	}
+	aliases := []string{}
+	for i, alias := range input.Aliases {
+		alias, err := canonicalWebsite(alias)
+		if err != nil {
+			v.add(fmt.Sprintf("aliases[%d]", i), apierr.InvalidFormat, err.Error())
+			continue
+		}
+		if alias != domain && !slices.Contains(aliases, alias) {
+			aliases = append(aliases, alias)
+		}
+	}
+	if err := v.err(); err != nil {
+		return err
+	}
+	slices.Sort(aliases)
+
+	key, err := ctx.GetStub().CreateCompositeKey(entityObjectType, []string{input.ID})
+	if err != nil {
+		return err
+	}
+	existing, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if existing != nil {
+		return apierr.New(apierr.AlreadyExists, "the entity %s already exists", input.ID)
+	}
+
+	for _, domain := range append([]string{domain}, aliases...) {
+		owner, err := entityIDOf(ctx, domain)
+		if err != nil {
+			return err
+		}
+		if owner != "" {
+			return apierr.New(apierr.AlreadyExists, "%s already belongs to the entity %s", domain, owner)
+		}
+		key, err := domainKey(ctx, domain)
+		if err != nil {
+			return err
+		}
+		if err := ctx.GetStub().PutState(key, []byte(input.ID)); err != nil {
+			return fmt.Errorf("failed to write domain: %v", err)
+		}
+	}
+
+	createdAt, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	return putEntity(ctx, &Entity{
+		ID:        input.ID,
+		Domain:    domain,
+		Name:      input.Name,
+		Country:   input.Country,
+		Aliases:   aliases,
+		CreatedAt: createdAt,
+	})
+}
+
+// MergeEntities merges the entity sourceID, registered twice by mistake, into targetID: the domain and
+// aliases of the source become aliases of the target, and the source records it was merged into the target.
+// Reviews linked to the source are linked to the target when next written or read
+func (s *ReviewContract) MergeEntities(ctx contractapi.TransactionContextInterface, sourceID, targetID string) (*Entity, error) {
+	if sourceID == targetID {
+		return nil, apierr.New(apierr.InvalidArgument, "can't merge an entity into itself")
+	}
+
+	source, err := readEntity(ctx, sourceID)
+	if err != nil {
+		return nil, err
+	}
+	target, err := readEntity(ctx, targetID)
+	if err != nil {
+		return nil, err
+	}
+	if source.MergedInto != "" {
+		return nil, apierr.New(apierr.Conflict, "the entity %s was already merged into %s", sourceID, source.MergedInto)
+	}
+	if target.MergedInto != "" {
+		return nil, apierr.New(apierr.Conflict, "the entity %s was merged into %s, merge into that one", targetID, target.MergedInto)
+	}
+
+	moved := append([]string{source.Domain}, source.Aliases...)
+	if len(target.Aliases)+len(moved) > maxAliases {
+		return nil, apierr.New(apierr.Conflict, "an entity can have at most %d aliases", maxAliases)
+	}
+	for _, domain := range moved {
+		key, err := domainKey(ctx, domain)
+		if err != nil {
+			return nil, err
+		}
+		if err := ctx.GetStub().PutState(key, []byte(targetID)); err != nil {
+			return nil, fmt.Errorf("failed to write domain: %v", err)
+		}
+	}
+
+	target.Aliases = append(target.Aliases, moved...)
+	slices.Sort(target.Aliases)
+	if err := putEntity(ctx, target); err != nil {
+		return nil, err
+	}
+
+	source.MergedInto = targetID
+	if err := putEntity(ctx, source); err != nil {
+		return nil, err
+	}
+
+	return target, nil
+}
+
+// ListEntities returns at most pageSize entities starting from bookmark, including merged ones
+func (s *ReviewContract) ListEntities(ctx contractapi.TransactionContextInterface, pageSize int32, bookmark string) (*PaginatedEntities, error) {
+	if pageSize < 1 || pageSize > maxPageSize {
+		return nil, apierr.New(apierr.InvalidArgument, "page size must be between 1 and %d", maxPageSize)
+	}
+
+	resultsIterator, responseMetadata, err := ctx.GetStub().GetStateByPartialCompositeKeyWithPagination(entityObjectType, []string{}, pageSize, bookmark)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if closeErr := resultsIterator.Close(); closeErr != nil {
+			err = closeErr
+		}
+	}()
+
+	entities := []*Entity{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var entity Entity
+		if err := decodeDocument(entityObjectType, queryResponse.Value, &entity); err != nil {
+			return nil, err
+		}
+		entities = append(entities, &entity)
+	}
+
+	return &PaginatedEntities{
+		Records:      entities,
+		FetchedCount: responseMetadata.FetchedRecordsCount,
+		Bookmark:     responseMetadata.Bookmark,
+	}, nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/edgeflare/fabreview/apierr"
+)
+
+// registerEntity registers an entity of domain and aliases as a moderator, and returns its ID
+func (s *testStub) registerEntity(name, domain string, aliases ...string) string {
+	s.t.Helper()
+
+	id := s.newID()
+	s.mustInvoke(moderator, "RegisterEntity", toJSON(s.t, EntityInput{ID: id, Domain: domain, Name: name, Aliases: aliases}))
+	return id
+}
+
+func TestCanonicalWebsite(t *testing.T) {
+	tests := []struct{ website, want string }{
+		{"technobd.com", "technobd.com"},
+		{"https://technobd.com/", "technobd.com"},
+		{"www.technobd.com", "technobd.com"},
+		{"https://user:pw@www.TechnoBD.com:443/about?x=1#top", "technobd.com"},
+		{"technobd.com.", "technobd.com"},
+		{"bücher.example", "xn--bcher-kva.example"},
+	}
+	for _, tt := range tests {
+		if got, err := canonicalWebsite(tt.website); err != nil || got != tt.want {
+			t.Errorf("canonicalWebsite(%q) = %q, %v, want %q", tt.website, got, err, tt.want)
+		}
+	}
+
+	for _, website := range []string{"", "localhost", "https://", "not a domain.com"} {
+		if got, err := canonicalWebsite(website); err == nil {
+			t.Errorf("canonicalWebsite(%q) = %q, want an error", website, got)
+		}
+	}
+}
+
+func TestRegisterEntity(t *testing.T) {
+	s := newTestStub(t)
+	s.wantError(apierr.Forbidden, alice, "RegisterEntity", toJSON(t, EntityInput{ID: s.newID(), Domain: "technobd.com", Name: "TechnoBD"}))
+	id := s.registerEntity("TechnoBD", "https://www.technobd.com/", "technobd.com.bd", "TECHNOBD.com.bd")
+
+	var page PaginatedEntities
+	s.mustInvokeJSON(&page, reader, "ListEntities", "10", "")
+	if len(page.Records) != 1 {
+		t.Fatalf("got %d entities, want 1", len(page.Records))
+	}
+	if entity := page.Records[0]; entity.ID != id || entity.Domain != "technobd.com" || !slices.Equal(entity.Aliases, []string{"technobd.com.bd"}) {
+		t.Errorf("got %+v", entity)
+	}
+
+	for _, website := range []string{"http://technobd.com/jobs", "www.technobd.com.bd"} {
+		if review := s.readReview(s.createReview(alice, website)); review.EntityID != id {
+			t.Errorf("review of %s links to entity %q, want %s", website, review.EntityID, id)
+		}
+	}
+	if review := s.readReview(s.createReview(alice, "example.com")); review.EntityID != "" {
+		t.Errorf("review of an unregistered website links to %s", review.EntityID)
+	}
+
+	s.wantError(apierr.AlreadyExists, moderator, "RegisterEntity", toJSON(t, EntityInput{ID: s.newID(), Domain: "other.example", Name: "Other", Aliases: []string{"technobd.com.bd"}}))
+	s.wantError(apierr.InvalidArgument, moderator, "RegisterEntity", toJSON(t, EntityInput{ID: s.newID(), Domain: "localhost", Name: "Local"}))
+	s.wantError(apierr.InvalidArgument, reader, "ListEntities", "0", "")
+}
+
+func TestMergeEntities(t *testing.T) {
+	s := newTestStub(t)
+	target := s.registerEntity("TechnoBD", "technobd.com")
+	source := s.registerEntity("Techno BD", "techno-bd.com", "technobd.net")
+	reviewID := s.createReview(alice, "techno-bd.com")
+
+	s.wantError(apierr.InvalidArgument, moderator, "MergeEntities", source, source)
+	var merged Entity
+	s.mustInvokeJSON(&merged, moderator, "MergeEntities", source, target)
+	if !slices.Equal(merged.Aliases, []string{"techno-bd.com", "technobd.net"}) {
+		t.Errorf("got aliases %v", merged.Aliases)
+	}
+	s.wantError(apierr.Conflict, moderator, "MergeEntities", source, target)
+	s.wantError(apierr.Conflict, moderator, "MergeEntities", target, source)
+
+	// reviews of the source link to the target when read
+	if review := s.readReview(reviewID); review.EntityID != target {
+		t.Errorf("got entity %q, want %s", review.EntityID, target)
+	}
+	if review := s.readReview(s.createReview(bob, "technobd.net")); review.EntityID != target {
+		t.Errorf("got entity %q for a new review, want %s", review.EntityID, target)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/edgeflare/fabreview/events"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// emitEvent sets the chaincode event of the current transaction.
+// Fabric keeps one event per transaction, so it should be called once, after the state is written.
+func emitEvent(ctx contractapi.TransactionContextInterface, name events.Name, reviewID, commentID, actor string, changed []string) error {
+	event := events.Event{
+		Version:   events.Version,
+		Name:      name,
+		TxID:      ctx.GetStub().GetTxID(),
+		ReviewID:  reviewID,
+		CommentID: commentID,
+		Actor:     actor,
+		Changed:   changed,
+	}
+
+	eventJSON, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %v", err)
+	}
+
+	if err := ctx.GetStub().SetEvent(string(name), eventJSON); err != nil {
+		return fmt.Errorf("failed to set event: %v", err)
+	}
+
+	return nil
+}
+
+// changedFields returns the names of the JSON fields that differ between before and after.
+// A nil before yields every field of after.
+func changedFields(before, after any) ([]string, error) {
+	beforeFields, err := toFieldMap(before)
+	if err != nil {
+		return nil, err
+	}
+	afterFields, err := toFieldMap(after)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := diffFields(beforeFields, afterFields)
+	names := make([]string, 0, len(changes))
+	for _, change := range changes {
+		names = append(names, change.Field)
+	}
+
+	return names, nil
+}
+
+// toFieldMap converts a value into a map of its JSON fields
+func toFieldMap(v any) (map[string]any, error) {
+	if v == nil {
+		return nil, nil
+	}
+
+	valueJSON, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields map[string]any
+	if err := json.Unmarshal(valueJSON, &fields); err != nil {
+		return nil, err
+	}
+
+	return fields, nil
+}
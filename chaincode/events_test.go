@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+
+	"github.com/edgeflare/fabreview/events"
+)
+
+// lastEvent decodes the event set by the last transaction
+func (s *testStub) lastEvent() *events.Event {
+	s.t.Helper()
+
+	if s.event == nil {
+		s.t.Fatal("the transaction set no event")
+	}
+	var event events.Event
+	if err := json.Unmarshal(s.event.Payload, &event); err != nil {
+		s.t.Fatal(err)
+	}
+	if string(event.Name) != s.event.EventName {
+		s.t.Errorf("event %s carries the payload of %s", s.event.EventName, event.Name)
+	}
+	return &event
+}
+
+func TestEvents(t *testing.T) {
+	s := newTestStub(t)
+	id := s.createReview(alice, "example.com")
+
+	event := s.lastEvent()
+	if event.Name != events.ReviewCreated || event.Version != events.Version || event.ReviewID != id || event.TxID != s.lastTx {
+		t.Errorf("got %+v", event)
+	}
+	if !slices.Contains(event.Changed, "title") {
+		t.Errorf("a new review didn't change %v", event.Changed)
+	}
+	author := event.Actor
+
+	s.mustInvoke(alice, "PatchReview", toJSON(t, ReviewPatch{ID: id, Summary: "A summary that was rewritten."}))
+	event = s.lastEvent()
+	if event.Name != events.ReviewUpdated || event.Actor != author || slices.Contains(event.Changed, "title") || !slices.Contains(event.Changed, "summary") {
+		t.Errorf("got %+v", event)
+	}
+
+	commentID := s.newID()
+	s.mustInvoke(bob, "AddComment", id, commentID, "Agreed.")
+	s.mustInvoke(alice, "Vote", id, "1", commentID)
+	event = s.lastEvent()
+	if event.Name != events.Voted || event.CommentID != commentID || event.Actor != author {
+		t.Errorf("got %+v", event)
+	}
+
+	// failed transactions emit nothing
+	s.invokeError(bob, "PatchReview", toJSON(t, ReviewPatch{ID: id, Title: "Not bob's"}))
+	if s.event != nil {
+		t.Errorf("a failed transaction set event %s", s.event.EventName)
+	}
+}
+
+func TestChangedFields(t *testing.T) {
+	before := &Review{ID: "1", Title: "a", Rating: 5}
+	after := &Review{ID: "1", Title: "b", Rating: 5, Positives: []string{"pay"}}
+
+	got, err := changedFields(before, after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"positives", "title"}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
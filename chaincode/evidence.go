@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"strings"
+	"time"
+
+	"github.com/edgeflare/fabreview/apierr"
+	"github.com/edgeflare/fabreview/events"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/oklog/ulid/v2"
+)
+
+// The author of a review can anchor the SHA-256 of a file backing it, eg an offer letter or a screenshot.
+// The file itself never reaches the ledger; whoever holds it can later prove it existed when it was anchored.
+const (
+	evidenceObjectType = "evidence" // evidence~reviewID~sha256
+	maxEvidence        = 20         // files anchored per review
+)
+
+// Evidence records the hash of a file anchored against a review
+type Evidence struct {
+	ReviewID    string    `json:"review_id"`
+	SHA256      string    `json:"sha256"`     // lowercase hex
+	MediaType   string    `json:"media_type"` // eg application/pdf
+	Size        int64     `json:"size"`       // in bytes
+	SubmitterID string    `json:"submitter_id"`
+	TxID        string    `json:"tx_id"` // transaction that anchored it
+	AnchoredAt  time.Time `json:"anchored_at"`
+	// DocType and SchemaVersion identify the stored document, see schemaVersion
+	DocType       string `json:"doc_type,omitzero" metadata:",optional"`
+	SchemaVersion int    `json:"schema_version,omitzero" metadata:",optional"`
+}
+
+// evidenceKey returns the composite key of the evidence with the given hash on a review
+func evidenceKey(ctx contractapi.TransactionContextInterface, reviewID, sha256 string) (string, error) {
+	return ctx.GetStub().CreateCompositeKey(evidenceObjectType, []string{reviewID, sha256})
+}
+
+// parseSHA256 validates a hex encoded SHA-256 and returns it in lowercase
+func parseSHA256(sha256 string) (string, error) {
+	sha256 = strings.ToLower(sha256)
+	decoded, err := hex.DecodeString(sha256)
+	if err != nil || len(decoded) != 32 {
+		return "", fmt.Errorf("sha256 must be 64 hex chars")
+	}
+	return sha256, nil
+}
+
+// countEvidence returns the number of files anchored against a review
+func countEvidence(ctx contractapi.TransactionContextInterface, reviewID string) (int, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(evidenceObjectType, []string{reviewID})
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if closeErr := resultsIterator.Close(); closeErr != nil {
+			err = closeErr
+		}
+	}()
+
+	count := 0
+	for resultsIterator.HasNext() {
+		if _, err := resultsIterator.Next(); err != nil {
+			return 0, err
+		}
+		count++
+	}
+
+	return count, nil
+}
+
+// AnchorEvidence records the SHA-256, media type and size of a file backing a review, along with
+// who anchored it and when. Only the review author can anchor evidence, and a hash is anchored once
+func (s *ReviewContract) AnchorEvidence(ctx contractapi.TransactionContextInterface, reviewID, sha256, mediaType string, size int64) error {
+	v := &validator{}
+	sha256, err := parseSHA256(sha256)
+	if err != nil {
+		v.add("sha256", apierr.InvalidFormat, err.Error())
+	}
+	parsedType, _, err := mime.ParseMediaType(mediaType)
+	if err != nil || !strings.Contains(parsedType, "/") {
+		v.add("media_type", apierr.InvalidFormat, "must be a media type, eg application/pdf")
+	} else {
+		v.text("media_type", parsedType, mediaTypeRule)
+	}
+	if size < 1 {
+		v.add("size", apierr.OutOfRange, "must be positive")
+	}
+	if err := v.err(); err != nil {
+		return err
+	}
+
+	review, err := s.verifyExistsAndOwner(ctx, reviewID)
+	if err != nil {
+		return err
+	}
+
+	key, err := evidenceKey(ctx, reviewID, sha256)
+	if err != nil {
+		return err
+	}
+	existing, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if existing != nil {
+		return apierr.New(apierr.AlreadyExists, "evidence %s has already been anchored against review %s", sha256, reviewID)
+	}
+
+	count, err := countEvidence(ctx, reviewID)
+	if err != nil {
+		return err
+	}
+	if count >= maxEvidence {
+		return apierr.New(apierr.Conflict, "at most %d files can be anchored against a review", maxEvidence)
+	}
+
+	anchoredAt, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	evidence := Evidence{
+		ReviewID:      reviewID,
+		SHA256:        sha256,
+		MediaType:     parsedType,
+		Size:          size,
+		SubmitterID:   review.UserID,
+		TxID:          ctx.GetStub().GetTxID(),
+		AnchoredAt:    anchoredAt,
+		DocType:       evidenceObjectType,
+		SchemaVersion: schemaVersion,
+	}
+	evidenceJSON, err := json.Marshal(evidence)
+	if err != nil {
+		return fmt.Errorf("failed to marshal evidence: %v", err)
+	}
+	if err := ctx.GetStub().PutState(key, evidenceJSON); err != nil {
+		return fmt.Errorf("failed to write evidence: %v", err)
+	}
+
+	return emitEvent(ctx, events.EvidenceAnchored, reviewID, "", review.UserID, nil)
+}
+
+// VerifyEvidence returns the anchoring record of a file backing a review, including the transaction that
+// anchored it, or an error if the hash wasn't anchored against the review
+func (s *ReviewContract) VerifyEvidence(ctx contractapi.TransactionContextInterface, reviewID, sha256 string) (*Evidence, error) {
+	_, err := ulid.ParseStrict(reviewID)
+	if err != nil {
+		return nil, apierr.New(apierr.InvalidArgument, "reviewID isn't ULID %v", err)
+	}
+	sha256, err = parseSHA256(sha256)
+	if err != nil {
+		return nil, apierr.New(apierr.InvalidArgument, "%v", err)
+	}
+
+	key, err := evidenceKey(ctx, reviewID, sha256)
+	if err != nil {
+		return nil, err
+	}
+	evidenceJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if evidenceJSON == nil {
+		return nil, apierr.New(apierr.NotFound, "evidence %s wasn't anchored against review %s", sha256, reviewID)
+	}
+
+	var evidence Evidence
+	if err := decodeDocument(evidenceObjectType, evidenceJSON, &evidence); err != nil {
+		return nil, err
+	}
+
+	return &evidence, nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/edgeflare/fabreview/apierr"
+	"github.com/edgeflare/fabreview/events"
+)
+
+func TestAnchorEvidence(t *testing.T) {
+	s := newTestStub(t)
+	id := s.createReview(alice, "example.com")
+	sum := sha256.Sum256([]byte("offer letter"))
+	hash := hex.EncodeToString(sum[:])
+
+	s.mustInvoke(alice, "AnchorEvidence", id, strings.ToUpper(hash), "application/pdf; charset=binary", "2048")
+	anchoredBy, anchoredAt := s.lastTx, s.now
+	if event := s.lastEvent(); event.Name != events.EvidenceAnchored || event.ReviewID != id {
+		t.Errorf("got event %+v", event)
+	}
+
+	var evidence Evidence
+	s.mustInvokeJSON(&evidence, reader, "VerifyEvidence", id, hash)
+	if evidence.SHA256 != hash || evidence.MediaType != "application/pdf" || evidence.Size != 2048 ||
+		evidence.TxID != anchoredBy || !evidence.AnchoredAt.Equal(anchoredAt) || evidence.SubmitterID != s.readReview(id).UserID {
+		t.Errorf("got %+v", evidence)
+	}
+
+	s.wantError(apierr.AlreadyExists, alice, "AnchorEvidence", id, hash, "application/pdf", "2048")
+	s.wantError(apierr.Forbidden, bob, "AnchorEvidence", id, strings.Repeat("0", 64), "image/png", "10")
+
+	other := sha256.Sum256([]byte("never anchored"))
+	s.wantError(apierr.NotFound, reader, "VerifyEvidence", id, hex.EncodeToString(other[:]))
+}
+
+func TestAnchorEvidenceInvalid(t *testing.T) {
+	s := newTestStub(t)
+	id := s.createReview(alice, "example.com")
+	hash := strings.Repeat("ab", 32)
+
+	for _, args := range [][]string{
+		{hash[:62], "application/pdf", "1"},
+		{strings.Repeat("zz", 32), "application/pdf", "1"},
+		{hash, "pdf", "1"},
+		{hash, "application/pdf", "0"},
+	} {
+		s.wantError(apierr.InvalidArgument, alice, "AnchorEvidence", append([]string{id}, args...)...)
+	}
+	s.wantError(apierr.InvalidArgument, reader, "VerifyEvidence", id, "not-a-hash")
+}
+
+func TestAnchorEvidenceLimit(t *testing.T) {
+	s := newTestStub(t)
+	id := s.createReview(alice, "example.com")
+	for i := range maxEvidence {
+		sum := sha256.Sum256([]byte(itoa(i)))
+		s.mustInvoke(alice, "AnchorEvidence", id, hex.EncodeToString(sum[:]), "image/png", "100")
+	}
+	s.wantError(apierr.Conflict, alice, "AnchorEvidence", id, strings.Repeat("0", 64), "image/png", "100")
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"time"
+
+	"github.com/edgeflare/fabreview/apierr"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/oklog/ulid/v2"
+)
+
+// ReviewVersion is one committed version of a review, as recorded on the ledger
+type ReviewVersion struct {
+	TxID      string        `json:"tx_id"`
+	Timestamp time.Time     `json:"timestamp"`
+	IsDelete  bool          `json:"is_delete"`
+	Record    *Review       `json:"record,omitzero" metadata:",optional"`  // nil when the version is a deletion
+	Changes   []FieldChange `json:"changes,omitzero" metadata:",optional"` // against the previous version
+}
+
+// FieldChange describes how a top-level review field changed between two versions
+type FieldChange struct {
+	Field string `json:"field"`
+	Old   any    `json:"old,omitzero" metadata:",optional"` // absent when the field was added
+	New   any    `json:"new,omitzero" metadata:",optional"` // absent when the field was removed
+}
+
+// GetReviewHistory returns every version of a review, oldest first, each with the fields
+// it changed compared to the version before it. Of a review that is now deleted, hidden or purged,
+// every version is redacted like ReadReview redacts the review, and only the names of changed fields
+// are kept, unless the caller is a moderator
+func (s *ReviewContract) GetReviewHistory(ctx contractapi.TransactionContextInterface, id string) ([]ReviewVersion, error) {
+	_, err := ulid.ParseStrict(id)
+	if err != nil {
+		return nil, apierr.New(apierr.InvalidArgument, "id isn't ULID %v", err)
+	}
+
+	redact, err := historyWithheld(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := reviewKey(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	// reviews moved by MigrateKeys have their earlier versions under their bare ID
+	entries, err := readHistory(ctx, id, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history of review %s: %v", id, err)
+	}
+
+	history := make([]ReviewVersion, 0, len(entries))
+	for _, entry := range entries {
+		version := ReviewVersion{TxID: entry.txID, Timestamp: entry.timestamp, IsDelete: entry.isDelete, Changes: entry.changes}
+		if !entry.isDelete {
+			var review Review
+			if err := json.Unmarshal(entry.value, &review); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal review at tx %s: %v", entry.txID, err)
+			}
+			version.Record = &review
+		}
+		if redact {
+			version.Record, version.Changes = redactedVersion(version.Record, version.Changes)
+		}
+		history = append(history, version)
+	}
+
+	return history, nil
+}
+
+// historyWithheld reports whether the history of a review must be redacted for the caller: its content is
+// withheld from readers, as it's deleted, hidden or purged, and the caller isn't a moderator
+func historyWithheld(ctx contractapi.TransactionContextInterface, id string) (bool, error) {
+	role, err := callerRole(ctx)
+	if err != nil {
+		return false, err
+	}
+	if role.includes(RoleModerator) {
+		return false, nil
+	}
+
+	reviewJSON, err := getReviewState(ctx, id)
+	if err != nil {
+		return false, err
+	}
+	if reviewJSON == nil {
+		return true, nil
+	}
+
+	var review Review
+	if err := decodeReview(reviewJSON, &review); err != nil {
+		return false, err
+	}
+	return review.Tombstone != nil || review.Hidden != nil, nil
+}
+
+// redactedVersion strips a version of a review down to what ReadReview returns of a withheld review,
+// and its changes down to the names of the fields
+func redactedVersion(record *Review, changes []FieldChange) (*Review, []FieldChange) {
+	if record != nil {
+		record = withheldReview(record)
+	}
+
+	return record, redactedChanges(changes)
+}
+
+// redactedChanges strips changes down to the names of the fields
+func redactedChanges(changes []FieldChange) []FieldChange {
+	redacted := make([]FieldChange, 0, len(changes))
+	for _, change := range changes {
+		redacted = append(redacted, FieldChange{Field: change.Field})
+	}
+
+	return redacted
+}
+
+// historyEntry is one committed version of a key, with the top-level fields it changed
+type historyEntry struct {
+	txID      string
+	timestamp time.Time
+	isDelete  bool
+	value     []byte
+	changes   []FieldChange
+}
+
+// readHistory returns every version of a JSON document, oldest first. A document that moved from one
+// key to another is read from each key in turn; the deletion from the former key is left out
+func readHistory(ctx contractapi.TransactionContextInterface, keys ...string) ([]historyEntry, error) {
+	var entries []historyEntry
+	for _, key := range keys {
+		keyEntries, err := readKeyHistory(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, keyEntries...)
+	}
+
+	written := map[string]bool{}
+	for _, entry := range entries {
+		if !entry.isDelete {
+			written[entry.txID] = true
+		}
+	}
+	entries = slices.DeleteFunc(entries, func(entry historyEntry) bool {
+		return entry.isDelete && written[entry.txID]
+	})
+
+	var previous map[string]any
+	for i, entry := range entries {
+		var fields map[string]any
+		if !entry.isDelete {
+			if err := json.Unmarshal(entry.value, &fields); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal value at tx %s: %v", entry.txID, err)
+			}
+		}
+		entries[i].changes = diffFields(previous, fields)
+		previous = fields
+	}
+
+	return entries, nil
+}
+
+// readKeyHistory returns every version of a key, oldest first
+func readKeyHistory(ctx contractapi.TransactionContextInterface, key string) ([]historyEntry, error) {
+	resultsIterator, err := ctx.GetStub().GetHistoryForKey(key)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if closeErr := resultsIterator.Close(); closeErr != nil {
+			err = closeErr
+		}
+	}()
+
+	// history comes newest first
+	var entries []historyEntry
+	for resultsIterator.HasNext() {
+		modification, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, historyEntry{
+			txID:      modification.TxId,
+			timestamp: modification.Timestamp.AsTime(),
+			isDelete:  modification.IsDelete,
+			value:     modification.Value,
+		})
+	}
+
+	slices.Reverse(entries)
+	return entries, nil
+}
+
+// diffFields compares two JSON documents decoded into maps, and returns the changed top-level fields sorted by name
+func diffFields(before, after map[string]any) []FieldChange {
+	names := make([]string, 0, len(before)+len(after))
+	for name := range before {
+		names = append(names, name)
+	}
+	for name := range after {
+		if _, ok := before[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+
+	var changes []FieldChange
+	for _, name := range names {
+		oldValue, newValue := before[name], after[name]
+		if reflect.DeepEqual(oldValue, newValue) {
+			continue
+		}
+		changes = append(changes, FieldChange{Field: name, Old: oldValue, New: newValue})
+	}
+
+	return changes
+}
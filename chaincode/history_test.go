@@ -0,0 +1,133 @@
+package main
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+)
+
+// changedNames returns the fields of changes
+func changedNames(changes []FieldChange) []string {
+	names := make([]string, 0, len(changes))
+	for _, change := range changes {
+		names = append(names, change.Field)
+	}
+	return names
+}
+
+func TestGetReviewHistory(t *testing.T) {
+	s := newTestStub(t)
+	id := s.createReview(alice, "example.com")
+	s.mustInvoke(alice, "PatchReview", toJSON(t, ReviewPatch{ID: id, Title: "A better title", Rating: 9}))
+
+	var history []ReviewVersion
+	s.mustInvokeJSON(&history, reader, "GetReviewHistory", id)
+	if len(history) != 2 {
+		t.Fatalf("got %d versions, want 2", len(history))
+	}
+	if history[0].Record.Title != "Review of example.com" || history[1].Record.Title != "A better title" {
+		t.Errorf("got titles %q and %q", history[0].Record.Title, history[1].Record.Title)
+	}
+	if !history[0].Timestamp.Before(history[1].Timestamp) {
+		t.Errorf("versions aren't oldest first: %v, %v", history[0].Timestamp, history[1].Timestamp)
+	}
+
+	names := changedNames(history[1].Changes)
+	for _, field := range []string{"title", "rating", "updated_at", "revision"} {
+		if !slices.Contains(names, field) {
+			t.Errorf("changes %v lack %s", names, field)
+		}
+	}
+	for _, change := range history[1].Changes {
+		if change.Field == "title" && (change.Old != "Review of example.com" || change.New != "A better title") {
+			t.Errorf("got title change %+v", change)
+		}
+	}
+}
+
+func TestGetReviewHistoryAcrossKeys(t *testing.T) {
+	s := newTestStub(t)
+	id := s.newID()
+	s.seed(Review{ID: id, Title: "Legacy", Website: "example.com", Summary: "Stored under its bare ID.", Rating: 4, Country: "BD", UserID: "anon-author"}, id)
+	s.mustInvoke(admin, "MigrateKeys", "", "10")
+
+	var history []ReviewVersion
+	s.mustInvokeJSON(&history, reader, "GetReviewHistory", id)
+	if len(history) != 2 {
+		t.Fatalf("got %d versions, want the bare key's and the composite key's", len(history))
+	}
+	for _, version := range history {
+		if version.IsDelete {
+			t.Errorf("the move shows as a deletion at tx %s", version.TxID)
+		}
+	}
+	// the move upgrades the document, and leaves its content as it was
+	names := changedNames(history[1].Changes)
+	if !slices.Contains(names, "schema_version") || slices.Contains(names, "title") || slices.Contains(names, "summary") {
+		t.Errorf("moving the review changed %v", names)
+	}
+}
+
+func TestDiffFields(t *testing.T) {
+	before := map[string]any{"title": "a", "rating": 5.0, "state": "Dhaka"}
+	after := map[string]any{"title": "b", "rating": 5.0, "locality": "Mirpur"}
+
+	want := []FieldChange{
+		{Field: "locality", New: "Mirpur"},
+		{Field: "state", Old: "Dhaka"},
+		{Field: "title", Old: "a", New: "b"},
+	}
+	if got := diffFields(before, after); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestGetReviewHistoryRedacted(t *testing.T) {
+	s := newTestStub(t)
+	id := s.createReview(alice, "example.com")
+	s.mustInvoke(alice, "PatchReview", toJSON(t, ReviewPatch{ID: id, Summary: "Names a person who asked not to be named."}))
+	s.mustInvoke(alice, "DeleteReview", id)
+
+	var history []ReviewVersion
+	s.mustInvokeJSON(&history, reader, "GetReviewHistory", id)
+	if len(history) != 3 {
+		t.Fatalf("got %d versions, want 3", len(history))
+	}
+	for _, version := range history {
+		if version.Record.Title != "" || version.Record.Summary != "" || version.Record.ID != id {
+			t.Errorf("version at tx %s isn't redacted: %+v", version.TxID, version.Record)
+		}
+		for _, change := range version.Changes {
+			if change.Old != nil || change.New != nil {
+				t.Errorf("change of %s at tx %s keeps its values", change.Field, version.TxID)
+			}
+		}
+	}
+	if history[2].Record.Tombstone == nil {
+		t.Error("the deletion is redacted away")
+	}
+
+	// moderators see every version in full
+	s.mustInvokeJSON(&history, moderator, "GetReviewHistory", id)
+	if history[1].Record.Summary != "Names a person who asked not to be named." {
+		t.Errorf("got %+v for a moderator", history[1].Record)
+	}
+}
+
+func TestGetReviewHistoryOfHiddenReview(t *testing.T) {
+	s := newTestStub(t)
+	id := s.createReview(alice, "example.com")
+	s.flagUntilHidden(id)
+
+	var history []ReviewVersion
+	s.mustInvokeJSON(&history, alice, "GetReviewHistory", id)
+	if len(history) == 0 || history[0].Record.Title != "" {
+		t.Errorf("got %+v, want redacted versions", history)
+	}
+
+	s.mustInvoke(moderator, "RestoreContent", id, "", "")
+	s.mustInvokeJSON(&history, alice, "GetReviewHistory", id)
+	if history[0].Record.Title == "" {
+		t.Error("versions stay redacted once the review is shown again")
+	}
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"github.com/edgeflare/fabreview/apierr"
+	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// User IDs used to be the certificate CommonName, which two CAs can both issue and which changes on
+// re-enrollment under another name. They are now scoped by MSP and derived from either
+//   - oidc::<mspID>::<sub>, when the configured subject attribute is present in the certificate
+//   - x509::<mspID>::<cid.GetID()>, otherwise
+const identityClaimObjectType = "idclaim" // idclaim~commonName, the user ID that claimed a legacy CommonName based one
+
+// userID returns the caller's stable user ID
+func (s *ReviewContract) userID(ctx contractapi.TransactionContextInterface) (string, error) {
+	clientIdentity, err := cid.New(ctx.GetStub())
+	if err != nil {
+		return "", fmt.Errorf("failed to get client identity: %v", err)
+	}
+
+	mspID, err := clientIdentity.GetMSPID()
+	if err != nil {
+		return "", fmt.Errorf("failed to get MSP ID: %v", err)
+	}
+
+	config, err := readConfig(ctx)
+	if err != nil {
+		return "", err
+	}
+	if config.SubjectAttribute != "" {
+		sub, found, err := clientIdentity.GetAttributeValue(config.SubjectAttribute)
+		if err != nil {
+			return "", fmt.Errorf("failed to read %s attribute: %v", config.SubjectAttribute, err)
+		}
+		if found && sub != "" {
+			return "oidc::" + mspID + "::" + sub, nil
+		}
+	}
+
+	id, err := clientIdentity.GetID()
+	if err != nil {
+		return "", fmt.Errorf("failed to get client ID: %v", err)
+	}
+
+	return "x509::" + mspID + "::" + id, nil
+}
+
+// ClaimLegacyIdentity transfers the reviews, comments, votes and flags recorded under the caller's plain
+// user ID, as earlier versions did, to the caller's pseudonyms. Members of the configured legacy MSP also
+// claim those recorded under their certificate CommonName. A CommonName can be claimed by one user ID only;
+// the first to claim it keeps it. Other MSPs' CAs can issue any CommonName, so they can't claim one.
+// It converts at most limit reviews starting at startKey, and returns the key to continue from,
+// which is empty once all reviews have been visited.
+func (s *ReviewContract) ClaimLegacyIdentity(ctx contractapi.TransactionContextInterface, startKey string, limit int) (string, error) {
+	if limit < 1 || limit > maxMigrationBatch {
+		return "", apierr.New(apierr.InvalidArgument, "limit must be between 1 and %d", maxMigrationBatch)
+	}
+
+	userID, err := s.userID(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to get user identity: %v", err)
+	}
+	secret, err := readPseudonymSecret(ctx)
+	if err != nil {
+		return "", err
+	}
+	legacyIDs := []string{userID}
+
+	mspID, err := cid.GetMSPID(ctx.GetStub())
+	if err != nil {
+		return "", fmt.Errorf("failed to get MSP ID: %v", err)
+	}
+	config, err := readConfig(ctx)
+	if err != nil {
+		return "", err
+	}
+	if config.LegacyMSP != "" && mspID == config.LegacyMSP {
+		legacyID, err := s.commonName(ctx)
+		if err != nil {
+			return "", err
+		}
+		if legacyID == "" {
+			return "", apierr.New(apierr.Forbidden, "the certificate has no CommonName to claim")
+		}
+		if err := claimCommonName(ctx, legacyID, userID); err != nil {
+			return "", err
+		}
+		legacyIDs = append(legacyIDs, legacyID)
+	}
+
+	// paginated queries aren't allowed in update transactions, so the batch is bounded by hand
+	return scanReviews(ctx, startKey, limit, func(id, key string, value []byte) error {
+		var review Review
+		if err := decodeReview(value, &review); err != nil {
+			return err
+		}
+
+		author := pseudonym(secret, review.ID, userID)
+		if claimReview(&review, legacyIDs, author) {
+			if err := putReview(ctx, &review); err != nil {
+				return err
+			}
+		}
+
+		return claimInteractions(ctx, review.ID, legacyIDs, author)
+	})
+}
+
+// claimCommonName records that userID claimed the CommonName legacyID, unless another user ID did first
+func claimCommonName(ctx contractapi.TransactionContextInterface, legacyID, userID string) error {
+	claimKey, err := ctx.GetStub().CreateCompositeKey(identityClaimObjectType, []string{legacyID})
+	if err != nil {
+		return err
+	}
+	claimedBy, err := ctx.GetStub().GetState(claimKey)
+	if err != nil {
+		return fmt.Errorf("failed to read from world state: %v", err)
+	}
+	switch {
+	case claimedBy == nil:
+		if err := ctx.GetStub().PutState(claimKey, []byte(userID)); err != nil {
+			return fmt.Errorf("failed to record claim: %v", err)
+		}
+	case string(claimedBy) != userID:
+		return apierr.New(apierr.Forbidden, "unauthorized: %s has already been claimed by another user", legacyID)
+	}
+
+	return nil
+}
+
+// claimReview rewrites legacyIDs to author in a review document, including votes and comments
+// not yet migrated out of it. It reports whether anything changed
+func claimReview(review *Review, legacyIDs []string, author string) bool {
+	changed := false
+	claim := func(id *string) {
+		if slices.Contains(legacyIDs, *id) {
+			*id = author
+			changed = true
+		}
+	}
+
+	claim(&review.UserID)
+	if review.Tombstone != nil {
+		claim(&review.Tombstone.DeletedBy)
+	}
+	for i := range review.Votes {
+		claim(&review.Votes[i].UserID)
+	}
+	for i := range review.Comments {
+		claim(&review.Comments[i].UserID)
+		for j := range review.Comments[i].Votes {
+			claim(&review.Comments[i].Votes[j].UserID)
+		}
+	}
+
+	return changed
+}
+
+// claimInteractions moves the separately stored comments, votes and flags of legacyIDs on a review to author
+func claimInteractions(ctx contractapi.TransactionContextInterface, reviewID string, legacyIDs []string, author string) error {
+	if err := claimVote(ctx, reviewID, "", legacyIDs, author); err != nil {
+		return err
+	}
+	if err := claimFlag(ctx, reviewID, reviewID, legacyIDs, author); err != nil {
+		return err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(commentObjectType, []string{reviewID})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := resultsIterator.Close(); closeErr != nil {
+			err = closeErr
+		}
+	}()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return err
+		}
+
+		var comment Comment
+		if err := decodeComment(queryResponse.Value, &comment); err != nil {
+			return err
+		}
+
+		if slices.Contains(legacyIDs, comment.UserID) {
+			comment.UserID = author
+			if err := putComment(ctx, reviewID, comment); err != nil {
+				return err
+			}
+		}
+		if err := claimVote(ctx, reviewID, comment.ID, legacyIDs, author); err != nil {
+			return err
+		}
+		if err := claimFlag(ctx, reviewID, comment.ID, legacyIDs, author); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// claimVote moves the votes of legacyIDs on a review or comment to author. Only one vote is kept,
+// author's own if they voted already, the others are taken out of the tally
+func claimVote(ctx contractapi.TransactionContextInterface, reviewID, commentID string, legacyIDs []string, author string) error {
+	kept, err := readVote(ctx, reviewID, commentID, author)
+	if err != nil {
+		return err
+	}
+	authorVoted := kept != None
+
+	delta := voteDelta{}
+	for _, legacyID := range legacyIDs {
+		legacyVote, err := readVote(ctx, reviewID, commentID, legacyID)
+		if err != nil {
+			return err
+		}
+		if legacyVote == None {
+			continue
+		}
+		if err := putVote(ctx, reviewID, commentID, Vote{UserID: legacyID, Value: None}); err != nil {
+			return err
+		}
+		if kept == None {
+			kept = legacyVote
+			continue
+		}
+		delta.add(legacyVote, -1)
+	}
+
+	if !authorVoted && kept != None {
+		if err := putVote(ctx, reviewID, commentID, Vote{UserID: author, Value: kept}); err != nil {
+			return err
+		}
+	}
+
+	return putVoteDelta(ctx, reviewID, commentID, delta)
+}
+
+// claimFlag moves the flags of legacyIDs on a review or comment to author. Only one flag is kept,
+// author's own if they flagged it already
+func claimFlag(ctx contractapi.TransactionContextInterface, reviewID, target string, legacyIDs []string, author string) error {
+	key, err := ctx.GetStub().CreateCompositeKey(flagObjectType, []string{reviewID, target, author})
+	if err != nil {
+		return err
+	}
+	existing, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return fmt.Errorf("failed to read from world state: %v", err)
+	}
+	authorFlagged := existing != nil
+
+	for _, legacyID := range legacyIDs {
+		legacyKey, err := ctx.GetStub().CreateCompositeKey(flagObjectType, []string{reviewID, target, legacyID})
+		if err != nil {
+			return err
+		}
+		flagJSON, err := ctx.GetStub().GetState(legacyKey)
+		if err != nil {
+			return fmt.Errorf("failed to read from world state: %v", err)
+		}
+		if flagJSON == nil {
+			continue
+		}
+		if err := ctx.GetStub().DelState(legacyKey); err != nil {
+			return fmt.Errorf("failed to delete flag: %v", err)
+		}
+		if authorFlagged {
+			continue
+		}
+
+		var flag Flag
+		if err := decodeDocument(flagObjectType, flagJSON, &flag); err != nil {
+			return err
+		}
+		flag.UserID = author
+		flagJSON, err = json.Marshal(flag)
+		if err != nil {
+			return fmt.Errorf("failed to marshal flag: %v", err)
+		}
+		if err := ctx.GetStub().PutState(key, flagJSON); err != nil {
+			return fmt.Errorf("failed to write flag: %v", err)
+		}
+		authorFlagged = true
+	}
+
+	return nil
+}
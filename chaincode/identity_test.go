@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/edgeflare/fabreview/apierr"
+)
+
+// seedLegacyReview stores a review written under the CommonName author, as earlier versions did
+func (s *testStub) seedLegacyReview(author string) string {
+	s.t.Helper()
+
+	id := s.newID()
+	s.seed(Review{ID: id, Title: "Legacy", Website: "example.com", Summary: "Written under a CommonName.", Rating: 6, Country: "BD", UserID: author}, reviewObjectType, id)
+	return id
+}
+
+func TestClaimLegacyIdentity(t *testing.T) {
+	s := newTestStub(t)
+	s.mustInvoke(admin, "SetConfig", toJSON(t, Config{RestoreWindowHours: 1, FlagThreshold: 3, LegacyMSP: "Org1MSP"}))
+	id := s.seedLegacyReview("alice")
+
+	// another MSP's CA can issue the same CommonName
+	impostor := identity{mspID: "Org2MSP", cn: "alice"}
+	if next := s.mustInvoke(impostor, "ClaimLegacyIdentity", "", "10"); len(next) != 0 {
+		t.Fatalf("got key %q after visiting every review", next)
+	}
+	if review := s.readReview(id); review.UserID != "alice" {
+		t.Fatalf("claimed by %s from another MSP", review.UserID)
+	}
+	s.wantError(apierr.Forbidden, impostor, "PatchReview", toJSON(t, ReviewPatch{ID: id, Title: "Taken over"}))
+
+	s.mustInvoke(alice, "ClaimLegacyIdentity", "", "10")
+	if review := s.readReview(id); review.UserID == "alice" || review.UserID == "" {
+		t.Fatalf("got author %q after claiming", review.UserID)
+	}
+	s.mustInvoke(alice, "PatchReview", toJSON(t, ReviewPatch{ID: id, Title: "Edited by its author"}))
+	if n := s.countKeys(identityClaimObjectType); n != 1 {
+		t.Errorf("got %d claims, want 1", n)
+	}
+}
+
+func TestClaimLegacyIdentityWithoutLegacyMSP(t *testing.T) {
+	s := newTestStub(t)
+	id := s.seedLegacyReview("alice")
+
+	s.mustInvoke(alice, "ClaimLegacyIdentity", "", "10")
+	if review := s.readReview(id); review.UserID != "alice" {
+		t.Errorf("CommonName claimed by %s without a legacy MSP", review.UserID)
+	}
+	if n := s.countKeys(identityClaimObjectType); n != 0 {
+		t.Errorf("got %d claims, want none", n)
+	}
+}
+
+func TestClaimedCommonName(t *testing.T) {
+	s := newTestStub(t)
+	s.mustInvoke(admin, "SetConfig", toJSON(t, Config{RestoreWindowHours: 1, FlagThreshold: 3, LegacyMSP: "Org1MSP"}))
+	s.seedLegacyReview("alice")
+	s.seed([]byte("x509::Org1MSP::someone-else"), identityClaimObjectType, "alice")
+
+	s.wantError(apierr.Forbidden, alice, "ClaimLegacyIdentity", "", "10")
+}
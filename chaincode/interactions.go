@@ -0,0 +1,280 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/edgeflare/fabreview/apierr"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Comments and votes are stored under their own composite keys instead of inside the Review document,
+// so that concurrent comments and votes on the same review don't cause MVCC_READ_CONFLICTs.
+const (
+	commentObjectType     = "comment"     // comment~reviewID~commentID
+	voteObjectType        = "vote"        // vote~reviewID~userID
+	commentVoteObjectType = "commentvote" // commentvote~reviewID~commentID~userID
+)
+
+const (
+	// maxMigrationBatch caps the number of reviews MigrateInteractions converts in one transaction
+	maxMigrationBatch = 100
+)
+
+// commentKey returns the composite key of a comment
+func commentKey(ctx contractapi.TransactionContextInterface, reviewID, commentID string) (string, error) {
+	return ctx.GetStub().CreateCompositeKey(commentObjectType, []string{reviewID, commentID})
+}
+
+// voteKey returns the composite key of a user's vote on a review, or on a comment when commentID isn't empty
+func voteKey(ctx contractapi.TransactionContextInterface, reviewID, commentID, userID string) (string, error) {
+	if commentID != "" {
+		return ctx.GetStub().CreateCompositeKey(commentVoteObjectType, []string{reviewID, commentID, userID})
+	}
+	return ctx.GetStub().CreateCompositeKey(voteObjectType, []string{reviewID, userID})
+}
+
+// readComment returns the comment stored under reviewID and commentID, or nil if there's none
+func readComment(ctx contractapi.TransactionContextInterface, reviewID, commentID string) (*Comment, error) {
+	key, err := commentKey(ctx, reviewID, commentID)
+	if err != nil {
+		return nil, err
+	}
+
+	commentJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if commentJSON == nil {
+		return nil, nil
+	}
+
+	var comment Comment
+	if err := decodeComment(commentJSON, &comment); err != nil {
+		return nil, err
+	}
+
+	return &comment, nil
+}
+
+// putComment writes a comment under its composite key. Votes are stored separately and never written here
+func putComment(ctx contractapi.TransactionContextInterface, reviewID string, comment Comment) error {
+	key, err := commentKey(ctx, reviewID, comment.ID)
+	if err != nil {
+		return err
+	}
+
+	comment.Votes = nil
+	comment.DocType, comment.SchemaVersion = commentDocType, schemaVersion
+	commentJSON, err := json.Marshal(comment)
+	if err != nil {
+		return fmt.Errorf("failed to marshal comment: %v", err)
+	}
+
+	return ctx.GetStub().PutState(key, commentJSON)
+}
+
+// putVote records a user's vote on a review or comment. A value of None removes the vote
+func putVote(ctx contractapi.TransactionContextInterface, reviewID, commentID string, vote Vote) error {
+	key, err := voteKey(ctx, reviewID, commentID, vote.UserID)
+	if err != nil {
+		return err
+	}
+
+	if vote.Value == None {
+		return ctx.GetStub().DelState(key)
+	}
+
+	vote.DocType, vote.SchemaVersion = voteDocType, schemaVersion
+	voteJSON, err := json.Marshal(vote)
+	if err != nil {
+		return fmt.Errorf("failed to marshal vote: %v", err)
+	}
+
+	return ctx.GetStub().PutState(key, voteJSON)
+}
+
+// readVotes returns the votes stored under the partial composite key objectType~attributes
+func readVotes(ctx contractapi.TransactionContextInterface, objectType string, attributes ...string) ([]Vote, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(objectType, attributes)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if closeErr := resultsIterator.Close(); closeErr != nil {
+			err = closeErr
+		}
+	}()
+
+	var votes []Vote
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var vote Vote
+		if err := decodeVote(queryResponse.Value, &vote); err != nil {
+			return nil, err
+		}
+		votes = append(votes, vote)
+	}
+
+	return votes, nil
+}
+
+// readComments returns the comments on a review, each with its votes
+func readComments(ctx contractapi.TransactionContextInterface, reviewID string) ([]Comment, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(commentObjectType, []string{reviewID})
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if closeErr := resultsIterator.Close(); closeErr != nil {
+			err = closeErr
+		}
+	}()
+
+	var comments []Comment
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var comment Comment
+		if err := decodeComment(queryResponse.Value, &comment); err != nil {
+			return nil, err
+		}
+
+		if comment.Hidden != nil {
+			comment.Comment = ""
+		}
+
+		comment.Votes, err = readVotes(ctx, commentVoteObjectType, reviewID, comment.ID)
+		if err != nil {
+			return nil, err
+		}
+		comments = append(comments, comment)
+	}
+
+	return comments, nil
+}
+
+// readInteractions adds the separately stored votes, comments and official response to review.
+// Votes and comments still embedded in a not yet migrated document are kept
+func readInteractions(ctx contractapi.TransactionContextInterface, review *Review) error {
+	votes, err := readVotes(ctx, voteObjectType, review.ID)
+	if err != nil {
+		return fmt.Errorf("failed to read votes of review %s: %v", review.ID, err)
+	}
+
+	comments, err := readComments(ctx, review.ID)
+	if err != nil {
+		return fmt.Errorf("failed to read comments of review %s: %v", review.ID, err)
+	}
+
+	response, err := readOfficialResponse(ctx, review.ID)
+	if err != nil {
+		return fmt.Errorf("failed to read official response to review %s: %v", review.ID, err)
+	}
+
+	review.Votes = append(review.Votes, votes...)
+	review.Comments = append(review.Comments, comments...)
+	review.OfficialResponse = response
+
+	return nil
+}
+
+// deleteInteractions removes all comments, votes, flags, evidence and the official response stored for a review
+func deleteInteractions(ctx contractapi.TransactionContextInterface, reviewID string) error {
+	for _, objectType := range []string{commentObjectType, voteObjectType, commentVoteObjectType, voteDeltaObjectType, voteTallyObjectType, flagObjectType, flagQueueObjectType, responseObjectType, evidenceObjectType} {
+		if err := deleteByPartialCompositeKey(ctx, objectType, reviewID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteCommentInteractions deletes the votes and flags on a comment, with its tally and moderation queue entry
+func deleteCommentInteractions(ctx contractapi.TransactionContextInterface, reviewID, commentID string) error {
+	for _, objectType := range []string{commentVoteObjectType, voteDeltaObjectType, voteTallyObjectType, flagObjectType, flagQueueObjectType} {
+		if err := deleteByPartialCompositeKey(ctx, objectType, reviewID, commentID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteByPartialCompositeKey deletes every key matching the partial composite key objectType~attributes
+func deleteByPartialCompositeKey(ctx contractapi.TransactionContextInterface, objectType string, attributes ...string) error {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(objectType, attributes)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := resultsIterator.Close(); closeErr != nil {
+			err = closeErr
+		}
+	}()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return err
+		}
+		if err := ctx.GetStub().DelState(queryResponse.Key); err != nil {
+			return fmt.Errorf("failed to delete %s: %v", queryResponse.Key, err)
+		}
+	}
+
+	return nil
+}
+
+// MigrateInteractions moves votes and comments embedded in review documents to their own keys.
+// It converts at most limit reviews starting at the review ID startKey, and returns the ID to continue
+// from, which is empty once all reviews have been migrated. Already migrated reviews are skipped.
+func (s *ReviewContract) MigrateInteractions(ctx contractapi.TransactionContextInterface, startKey string, limit int) (string, error) {
+	if limit < 1 || limit > maxMigrationBatch {
+		return "", apierr.New(apierr.InvalidArgument, "limit must be between 1 and %d", maxMigrationBatch)
+	}
+
+	// paginated queries aren't allowed in update transactions, so the batch is bounded by hand
+	return scanReviews(ctx, startKey, limit, func(id, key string, value []byte) error {
+		var review Review
+		if err := decodeReview(value, &review); err != nil {
+			return err
+		}
+		if len(review.Votes) == 0 && len(review.Comments) == 0 {
+			return nil
+		}
+
+		if err := migrateVotes(ctx, review.ID, "", review.Votes); err != nil {
+			return err
+		}
+		for _, comment := range review.Comments {
+			if err := putComment(ctx, review.ID, comment); err != nil {
+				return err
+			}
+			if err := migrateVotes(ctx, review.ID, comment.ID, comment.Votes); err != nil {
+				return err
+			}
+		}
+
+		review.Votes = nil
+		review.Comments = nil
+		return putReview(ctx, &review)
+	})
+}
+
+// migrateVotes stores embedded votes under their own keys, along with a single tally delta for all of them
+func migrateVotes(ctx contractapi.TransactionContextInterface, reviewID, commentID string, votes []Vote) error {
+	delta := voteDelta{}
+	for _, vote := range votes {
+		if err := putVote(ctx, reviewID, commentID, vote); err != nil {
+			return err
+		}
+		delta.add(vote.Value, 1)
+	}
+	return putVoteDelta(ctx, reviewID, commentID, delta)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+func TestCommentsAndVotesHaveTheirOwnKeys(t *testing.T) {
+	s := newTestStub(t)
+	id := s.createReview(alice, "example.com")
+	commentID := s.newID()
+	s.mustInvoke(bob, "AddComment", id, commentID, "Same experience here.")
+	s.mustInvoke(bob, "Vote", id, "-1", "")
+
+	key, err := shim.CreateCompositeKey(reviewObjectType, []string{id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stored Review
+	if err := decodeReview(s.State[key], &stored); err != nil {
+		t.Fatal(err)
+	}
+	if len(stored.Votes) != 0 || len(stored.Comments) != 0 {
+		t.Errorf("the review document holds votes %+v and comments %+v", stored.Votes, stored.Comments)
+	}
+
+	review := s.readReview(id)
+	if len(review.Comments) != 1 || review.Comments[0].ID != commentID {
+		t.Errorf("got comments %+v, want %s", review.Comments, commentID)
+	}
+	if len(review.Votes) != 2 {
+		t.Errorf("got votes %+v, want the author's and bob's", review.Votes)
+	}
+}
+
+func TestMigrateInteractions(t *testing.T) {
+	s := newTestStub(t)
+	id, commentID := s.newID(), s.newID()
+	s.seed(Review{
+		ID:      id,
+		Title:   "Embedded",
+		Website: "example.com",
+		Summary: "Written before comments and votes had their own keys.",
+		Rating:  5,
+		Country: "BD",
+		UserID:  "anon-author",
+		Votes:   []Vote{{UserID: "anon-author", Value: Upvote}, {UserID: "anon-other", Value: Downvote}},
+		Comments: []Comment{{
+			ID:      commentID,
+			UserID:  "anon-other",
+			Comment: "An embedded comment.",
+			Votes:   []Vote{{UserID: "anon-author", Value: Upvote}},
+		}},
+	}, reviewObjectType, id)
+
+	if next := s.mustInvoke(admin, "MigrateInteractions", "", "10"); len(next) != 0 {
+		t.Errorf("got next key %q after a single review", next)
+	}
+
+	review := s.readReview(id)
+	if len(review.Votes) != 2 || len(review.Comments) != 1 || review.Comments[0].ID != commentID {
+		t.Fatalf("got votes %+v and comments %+v", review.Votes, review.Comments)
+	}
+
+	var tally VoteTally
+	s.mustInvokeJSON(&tally, reader, "GetVoteTally", id, "")
+	if tally.Upvotes != 1 || tally.Downvotes != 1 {
+		t.Errorf("got review tally %+v", tally)
+	}
+	s.mustInvokeJSON(&tally, reader, "GetVoteTally", id, commentID)
+	if tally.Upvotes != 1 {
+		t.Errorf("got comment tally %+v", tally)
+	}
+
+	// migrating again doesn't count the votes twice
+	s.mustInvoke(admin, "MigrateInteractions", "", "10")
+	s.mustInvokeJSON(&tally, reader, "GetVoteTally", id, "")
+	if tally.Upvotes != 1 || tally.Downvotes != 1 {
+		t.Errorf("got review tally %+v after migrating twice", tally)
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/edgeflare/fabreview/apierr"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+)
+
+// Every document is stored under a composite key starting with its type, eg review~reviewID or
+// comment~reviewID~commentID, so scans of one type never see another. Reviews written before were
+// stored under their bare ID; they're read from there until MigrateKeys, or their next write, moves them.
+const reviewObjectType = "review" // review~reviewID
+
+// reviewKey returns the composite key of a review
+func reviewKey(ctx contractapi.TransactionContextInterface, id string) (string, error) {
+	return ctx.GetStub().CreateCompositeKey(reviewObjectType, []string{id})
+}
+
+// splitKey returns the attributes of a composite key, which must be of objectType
+func splitKey(ctx contractapi.TransactionContextInterface, key, objectType string) ([]string, error) {
+	keyType, attributes, err := ctx.GetStub().SplitCompositeKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to split key %q: %v", key, err)
+	}
+	if keyType != objectType {
+		return nil, fmt.Errorf("%q isn't a %s key", key, objectType)
+	}
+	return attributes, nil
+}
+
+// reviewIDOf returns the ID of the review stored under a composite key
+func reviewIDOf(ctx contractapi.TransactionContextInterface, key string) (string, error) {
+	attributes, err := splitKey(ctx, key, reviewObjectType)
+	if err != nil {
+		return "", err
+	}
+	if len(attributes) != 1 {
+		return "", fmt.Errorf("%q isn't a review key", key)
+	}
+	return attributes[0], nil
+}
+
+// getReviewState returns the stored review document with id, nil if there's none
+func getReviewState(ctx contractapi.TransactionContextInterface, id string) ([]byte, error) {
+	key, err := reviewKey(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	reviewJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if reviewJSON != nil {
+		return reviewJSON, nil
+	}
+
+	// not moved yet
+	reviewJSON, err = ctx.GetStub().GetState(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	return reviewJSON, nil
+}
+
+// putReview stores a review under its composite key, and removes it from its bare ID if it was stored there
+func putReview(ctx contractapi.TransactionContextInterface, review *Review) error {
+	key, err := reviewKey(ctx, review.ID)
+	if err != nil {
+		return err
+	}
+	reviewJSON, err := json.Marshal(review)
+	if err != nil {
+		return fmt.Errorf("failed to marshal review: %v", err)
+	}
+	if err := ctx.GetStub().PutState(key, reviewJSON); err != nil {
+		return fmt.Errorf("failed to write review: %v", err)
+	}
+
+	return deleteLegacyReview(ctx, review.ID)
+}
+
+// deleteReviewState removes a review from world state
+func deleteReviewState(ctx contractapi.TransactionContextInterface, id string) error {
+	key, err := reviewKey(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := ctx.GetStub().DelState(key); err != nil {
+		return fmt.Errorf("failed to delete review: %v", err)
+	}
+
+	return deleteLegacyReview(ctx, id)
+}
+
+// deleteLegacyReview removes a review from its bare ID key, if it's still stored there
+func deleteLegacyReview(ctx contractapi.TransactionContextInterface, id string) error {
+	legacyJSON, err := ctx.GetStub().GetState(id)
+	if err != nil {
+		return fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if legacyJSON == nil {
+		return nil
+	}
+	if err := ctx.GetStub().DelState(id); err != nil {
+		return fmt.Errorf("failed to delete review %s from its bare ID: %v", id, err)
+	}
+	return nil
+}
+
+// reviewCursor walks the reviews of an iterator, in ID order
+type reviewCursor struct {
+	ctx      contractapi.TransactionContextInterface
+	iterator shim.StateQueryIteratorInterface
+	bare     bool // the iterator holds reviews stored under their bare ID
+	id       string
+	current  *queryresult.KV // nil once all were walked
+}
+
+// next moves the cursor to the following review
+func (c *reviewCursor) next() error {
+	c.current = nil
+	if !c.iterator.HasNext() {
+		return nil
+	}
+	queryResponse, err := c.iterator.Next()
+	if err != nil {
+		return err
+	}
+	c.current, c.id = queryResponse, queryResponse.Key
+	if !c.bare {
+		c.id, err = reviewIDOf(c.ctx, queryResponse.Key)
+	}
+	return err
+}
+
+// scanReviews calls visit with each review, in ID order, starting at the review with startID, or the first one
+// when it's empty. It stops after limit reviews, unless limit is negative, and returns the ID to continue from,
+// empty once all were visited. Reviews MigrateKeys hasn't moved yet are visited with their bare ID as key.
+// Update transactions can't query composite key ranges, so reviews before startID are skipped over
+func scanReviews(ctx contractapi.TransactionContextInterface, startID string, limit int, visit func(id, key string, value []byte) error) (string, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(reviewObjectType, []string{})
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if closeErr := resultsIterator.Close(); closeErr != nil {
+			err = closeErr
+		}
+	}()
+
+	// range queries only see simple keys, ie reviews not moved yet
+	legacyIterator, err := ctx.GetStub().GetStateByRange(startID, "")
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if closeErr := legacyIterator.Close(); closeErr != nil {
+			err = closeErr
+		}
+	}()
+
+	moved := &reviewCursor{ctx: ctx, iterator: resultsIterator}
+	legacy := &reviewCursor{ctx: ctx, iterator: legacyIterator, bare: true}
+	for _, cursor := range []*reviewCursor{moved, legacy} {
+		if err := cursor.next(); err != nil {
+			return "", err
+		}
+	}
+
+	visited := 0
+	for moved.current != nil || legacy.current != nil {
+		cursor := moved
+		if moved.current == nil || legacy.current != nil && legacy.id < moved.id {
+			cursor = legacy
+		}
+
+		if cursor.id >= startID {
+			if visited == limit {
+				return cursor.id, nil
+			}
+			visited++
+
+			if err := visit(cursor.id, cursor.current.Key, cursor.current.Value); err != nil {
+				return "", err
+			}
+		}
+
+		if err := cursor.next(); err != nil {
+			return "", err
+		}
+	}
+
+	return "", nil
+}
+
+// forEachReview calls visit with every review, see scanReviews
+func forEachReview(ctx contractapi.TransactionContextInterface, visit func(id, key string, value []byte) error) error {
+	_, err := scanReviews(ctx, "", -1, visit)
+	return err
+}
+
+// storedReviews returns the number of stored reviews, counting no more than limit+1, so callers can tell whether
+// there are more than limit without reading them all. Unlike CountReviews it doesn't rely on the counters,
+// which don't include reviews written by earlier versions until RecountAll
+func storedReviews(ctx contractapi.TransactionContextInterface, limit int) (int, error) {
+	count := 0
+	_, err := scanReviews(ctx, "", limit+1, func(id, key string, value []byte) error {
+		count++
+		return nil
+	})
+	return count, err
+}
+
+// MigrateKeys moves reviews stored under their bare ID to their composite key, upgrading them to the current
+// schema version on the way. It moves at most limit reviews starting at startKey, and returns the key to
+// continue from, which is empty once all reviews have been moved.
+func (s *ReviewContract) MigrateKeys(ctx contractapi.TransactionContextInterface, startKey string, limit int) (string, error) {
+	if limit < 1 || limit > maxMigrationBatch {
+		return "", apierr.New(apierr.InvalidArgument, "limit must be between 1 and %d", maxMigrationBatch)
+	}
+
+	// range queries only see simple keys, ie reviews not moved yet
+	resultsIterator, err := ctx.GetStub().GetStateByRange(startKey, "")
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if closeErr := resultsIterator.Close(); closeErr != nil {
+			err = closeErr
+		}
+	}()
+
+	moved := 0
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return "", err
+		}
+		if moved == limit {
+			return queryResponse.Key, nil
+		}
+		moved++
+
+		reviewJSON, err := upgradeDocument(reviewDocType, queryResponse.Value)
+		if err != nil {
+			return "", fmt.Errorf("failed to upgrade review %s: %v", queryResponse.Key, err)
+		}
+		key, err := reviewKey(ctx, queryResponse.Key)
+		if err != nil {
+			return "", err
+		}
+		if err := ctx.GetStub().PutState(key, reviewJSON); err != nil {
+			return "", fmt.Errorf("failed to write review: %v", err)
+		}
+		if err := ctx.GetStub().DelState(queryResponse.Key); err != nil {
+			return "", fmt.Errorf("failed to delete review %s from its bare ID: %v", queryResponse.Key, err)
+		}
+	}
+
+	return "", nil
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/edgeflare/fabreview/apierr"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// seedBareReview stores a review under its bare ID, as before composite keys
+func (s *testStub) seedBareReview(website string) string {
+	s.t.Helper()
+
+	id := s.newID()
+	s.seed(Review{ID: id, Title: "Bare", Website: website, Summary: "Stored under its bare ID.", Rating: 5, Country: "BD", UserID: "anon-author"}, id)
+	return id
+}
+
+func TestReviewKeys(t *testing.T) {
+	s := newTestStub(t)
+	id := s.newID()
+
+	s.inTransaction(reader, func(ctx contractapi.TransactionContextInterface) error {
+		key, err := reviewKey(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := reviewIDOf(ctx, key); err != nil || got != id {
+			t.Errorf("got %q, %v from %q", got, err, key)
+		}
+
+		commentKey, err := ctx.GetStub().CreateCompositeKey(commentObjectType, []string{id, s.newID()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := reviewIDOf(ctx, commentKey); err == nil {
+			t.Error("read a review ID from a comment key")
+		}
+		if attributes, err := splitKey(ctx, commentKey, commentObjectType); err != nil || len(attributes) != 2 || attributes[0] != id {
+			t.Errorf("got %v, %v", attributes, err)
+		}
+		return nil
+	})
+}
+
+func TestMigrateKeys(t *testing.T) {
+	s := newTestStub(t)
+	bare := []string{s.seedBareReview("a.example"), s.seedBareReview("b.example"), s.seedBareReview("c.example")}
+	moved := s.createReview(alice, "d.example")
+	slices.Sort(bare) // keys are visited in order
+
+	// readable before they're moved
+	if review := s.readReview(bare[0]); review.Title != "Bare" {
+		t.Fatalf("got %+v from the bare ID", review)
+	}
+
+	s.wantError(apierr.InvalidArgument, admin, "MigrateKeys", "", "0")
+	s.wantError(apierr.Forbidden, alice, "MigrateKeys", "", "10")
+	next := string(s.mustInvoke(admin, "MigrateKeys", "", "2"))
+	if next != bare[2] {
+		t.Fatalf("got key %q after 2 reviews, want %q", next, bare[2])
+	}
+	if next := s.mustInvoke(admin, "MigrateKeys", next, "2"); len(next) != 0 {
+		t.Fatalf("got key %q after moving every review", next)
+	}
+
+	for _, id := range bare {
+		if s.State[id] != nil {
+			t.Errorf("review %s left under its bare ID", id)
+		}
+		if review := s.readReview(id); review.SchemaVersion != schemaVersion || review.Title != "Bare" {
+			t.Errorf("got %+v after moving", review)
+		}
+	}
+
+	var all []QueryResult
+	s.mustInvokeJSON(&all, reader, "ReadAllReviews")
+	var ids []string
+	for _, result := range all {
+		ids = append(ids, result.Record.ID)
+	}
+	want := append(slices.Clone(bare), moved)
+	slices.Sort(want)
+	if !slices.Equal(ids, want) {
+		t.Errorf("got reviews %v, want %v", ids, want)
+	}
+}
+
+func TestBareReviewMovedOnWrite(t *testing.T) {
+	s := newTestStub(t)
+	id := s.seedBareReview("example.com")
+	s.flagUntilHidden(id)
+
+	if s.State[id] != nil {
+		t.Error("review left under its bare ID after being written")
+	}
+	if n := s.countKeys(reviewObjectType, id); n != 1 {
+		t.Errorf("got %d review keys, want 1", n)
+	}
+}
+
+func TestBatchesVisitBareReviews(t *testing.T) {
+	s := newTestStub(t)
+	s.mustInvoke(admin, "SetConfig", toJSON(t, Config{RestoreWindowHours: 1, FlagThreshold: 3, LegacyMSP: "Org1MSP"}))
+	claimed := s.newID()
+	s.seed(Review{ID: claimed, Title: "Bare", Website: "example.com", Summary: "Written under a CommonName.", Rating: 4, Country: "BD", UserID: "alice"}, claimed)
+	embedding := s.newID()
+	s.seed(Review{ID: embedding, Title: "Bare", Website: "example.com", Summary: "Comments embedded.", Rating: 6, Country: "BD", UserID: "anon-author",
+		Comments: []Comment{{ID: s.newID(), UserID: "anon-commenter", Comment: "Agreed."}}}, embedding)
+
+	var stats EntityStats
+	s.mustInvokeJSON(&stats, admin, "RebuildStats", "example.com")
+	if stats.Count != 2 || stats.Sum != 10 {
+		t.Errorf("rebuilt %+v, want both bare reviews", stats)
+	}
+
+	var batch MigrationBatch
+	s.mustInvokeJSON(&batch, admin, "MigrateBatch", "0", "", "10")
+	if batch.Migrated != 2 {
+		t.Errorf("migrated %d documents, want both bare reviews", batch.Migrated)
+	}
+	if _, version := storedVersion(t, s.State[claimed]); version != schemaVersion {
+		t.Errorf("bare review left at schema version %d", version)
+	}
+
+	if next := s.mustInvoke(alice, "ClaimLegacyIdentity", "", "10"); len(next) != 0 {
+		t.Fatalf("got key %q after visiting every review", next)
+	}
+	if review := s.readReview(claimed); review.UserID == "alice" {
+		t.Error("the bare review wasn't claimed")
+	}
+
+	if next := s.mustInvoke(admin, "MigrateInteractions", "", "10"); len(next) != 0 {
+		t.Fatalf("got key %q after visiting every review", next)
+	}
+	if n := s.countKeys(commentObjectType, embedding); n != 1 {
+		t.Errorf("got %d comments moved out of the bare review, want 1", n)
+	}
+	if s.State[claimed] != nil || s.State[embedding] != nil {
+		t.Error("rewritten reviews left under their bare ID")
+	}
+}
+
+func TestScanReviews(t *testing.T) {
+	s := newTestStub(t)
+	ids := []string{s.seedBareReview("a.example"), s.createReview(alice, "b.example"), s.seedBareReview("c.example"), s.createReview(alice, "d.example")}
+	slices.Sort(ids)
+
+	var visited []string
+	bookmark := ""
+	for pages := 0; ; pages++ {
+		if pages == len(ids) {
+			t.Fatalf("still scanning after %d pages", pages)
+		}
+		s.inTransaction(reader, func(ctx contractapi.TransactionContextInterface) error {
+			var err error
+			bookmark, err = scanReviews(ctx, bookmark, 3, func(id, key string, value []byte) error {
+				visited = append(visited, id)
+				return nil
+			})
+			return err
+		})
+		if bookmark == "" {
+			break
+		}
+	}
+
+	if !slices.Equal(visited, ids) {
+		t.Errorf("visited %v, want %v", visited, ids)
+	}
+}
@@ -0,0 +1,525 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/edgeflare/fabreview/apierr"
+	"github.com/edgeflare/fabreview/events"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/oklog/ulid/v2"
+)
+
+const (
+	flagObjectType          = "flag"      // flag~reviewID~targetID~userID
+	flagQueueObjectType     = "flagqueue" // flagqueue~reviewID~targetID, items waiting for a moderator
+	moderationLogObjectType = "modlog"    // modlog~reviewID~txID
+)
+
+// FlagReason is the category a user reports content under
+type FlagReason string
+
+const (
+	FlagAbuse          FlagReason = "abuse"
+	FlagDoxxing        FlagReason = "doxxing"
+	FlagSpam           FlagReason = "spam"
+	FlagMisinformation FlagReason = "misinformation"
+	FlagOther          FlagReason = "other"
+)
+
+var flagReasons = []FlagReason{FlagAbuse, FlagDoxxing, FlagSpam, FlagMisinformation, FlagOther}
+
+// Moderation actions recorded in the moderation log
+const (
+	ModerationAutoHide = "auto_hide" // flag threshold reached
+	ModerationDismiss  = "dismiss"   // flags rejected, content shown again
+	ModerationUphold   = "uphold"    // flags accepted, content stays hidden
+	ModerationRestore  = "restore"   // hidden content shown again
+)
+
+// Flag is a single user's report of a review or comment
+type Flag struct {
+	UserID    string     `json:"user_id"`
+	Reason    FlagReason `json:"reason"`
+	FlaggedAt time.Time  `json:"flagged_at"`
+	// DocType and SchemaVersion identify the stored document, see schemaVersion
+	DocType       string `json:"doc_type,omitzero" metadata:",optional"`
+	SchemaVersion int    `json:"schema_version,omitzero" metadata:",optional"`
+}
+
+// Hidden records why a review or comment is hidden from readers
+type Hidden struct {
+	HiddenAt time.Time `json:"hidden_at"`
+	HiddenBy string    `json:"hidden_by,omitzero" metadata:",optional"` // moderator, empty when hidden by the flag threshold
+	Reason   string    `json:"reason"`
+}
+
+// FlaggedItem is a review or comment waiting for a moderator, with the flags raised against it
+type FlaggedItem struct {
+	ReviewID  string  `json:"review_id"`
+	CommentID string  `json:"comment_id,omitzero" metadata:",optional"`
+	Hidden    *Hidden `json:"hidden,omitzero" metadata:",optional"`
+	Flags     []Flag  `json:"flags"` // read from the flags, not stored with the item
+	// DocType and SchemaVersion identify the stored document, see schemaVersion
+	DocType       string `json:"doc_type,omitzero" metadata:",optional"`
+	SchemaVersion int    `json:"schema_version,omitzero" metadata:",optional"`
+}
+
+// PaginatedFlaggedItems is a page of the moderation queue
+type PaginatedFlaggedItems struct {
+	Records      []FlaggedItem `json:"records"`
+	FetchedCount int32         `json:"fetched_count"`
+	Bookmark     string        `json:"bookmark"`
+}
+
+// ModerationAction is an entry of the moderation log, kept on the ledger for transparency
+type ModerationAction struct {
+	TxID      string    `json:"tx_id"`
+	ReviewID  string    `json:"review_id"`
+	CommentID string    `json:"comment_id,omitzero" metadata:",optional"`
+	Action    string    `json:"action"`
+	Moderator string    `json:"moderator,omitzero" metadata:",optional"` // empty for automatic actions
+	Note      string    `json:"note,omitzero" metadata:",optional"`
+	At        time.Time `json:"at"`
+	// DocType and SchemaVersion identify the stored document, see schemaVersion
+	DocType       string `json:"doc_type,omitzero" metadata:",optional"`
+	SchemaVersion int    `json:"schema_version,omitzero" metadata:",optional"`
+}
+
+// FlagReview reports a review. Once the configured number of users flagged it, the review is hidden until a moderator resolves the flags
+func (s *ReviewContract) FlagReview(ctx contractapi.TransactionContextInterface, reviewID, reason string) error {
+	return s.flag(ctx, reviewID, "", FlagReason(reason))
+}
+
+// FlagComment reports a comment. Once the configured number of users flagged it, the comment is hidden until a moderator resolves the flags
+func (s *ReviewContract) FlagComment(ctx contractapi.TransactionContextInterface, reviewID, commentID, reason string) error {
+	_, err := ulid.ParseStrict(commentID)
+	if err != nil {
+		return apierr.New(apierr.InvalidArgument, "commentID isn't ULID %v", err)
+	}
+	return s.flag(ctx, reviewID, commentID, FlagReason(reason))
+}
+
+// ListFlagged returns a page of the reviews and comments waiting for a moderator. Only moderators may list them
+func (s *ReviewContract) ListFlagged(ctx contractapi.TransactionContextInterface, pageSize int32, bookmark string) (*PaginatedFlaggedItems, error) {
+	if pageSize < 1 || pageSize > maxPageSize {
+		return nil, apierr.New(apierr.InvalidArgument, "page size must be between 1 and %d", maxPageSize)
+	}
+
+	resultsIterator, responseMetadata, err := ctx.GetStub().GetStateByPartialCompositeKeyWithPagination(flagQueueObjectType, []string{}, pageSize, bookmark)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if closeErr := resultsIterator.Close(); closeErr != nil {
+			err = closeErr
+		}
+	}()
+
+	items := []FlaggedItem{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var item FlaggedItem
+		if err := decodeDocument(flagQueueObjectType, queryResponse.Value, &item); err != nil {
+			return nil, err
+		}
+
+		item.Flags, err = readFlags(ctx, item.ReviewID, item.CommentID)
+		if err != nil {
+			return nil, err
+		}
+		item.Hidden, err = readHidden(ctx, item.ReviewID, item.CommentID)
+		if err != nil {
+			return nil, err
+		}
+
+		items = append(items, item)
+	}
+
+	return &PaginatedFlaggedItems{
+		Records:      items,
+		FetchedCount: responseMetadata.FetchedRecordsCount,
+		Bookmark:     responseMetadata.Bookmark,
+	}, nil
+}
+
+// ResolveFlag settles the flags raised against a review, or one of its comments if commentID is provided.
+// decision is either dismiss, which shows the content again, or uphold, which keeps it hidden.
+// The flags are cleared and the item leaves the moderation queue. Only moderators may resolve flags
+func (s *ReviewContract) ResolveFlag(ctx contractapi.TransactionContextInterface, reviewID, commentID, decision, note string) error {
+	if decision != ModerationDismiss && decision != ModerationUphold {
+		return apierr.New(apierr.InvalidArgument, "decision must be %s or %s", ModerationDismiss, ModerationUphold)
+	}
+
+	if err := validateNote(note); err != nil {
+		return err
+	}
+
+	queueKey, err := ctx.GetStub().CreateCompositeKey(flagQueueObjectType, []string{reviewID, targetID(reviewID, commentID)})
+	if err != nil {
+		return err
+	}
+	queued, err := ctx.GetStub().GetState(queueKey)
+	if err != nil {
+		return fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if queued == nil {
+		return apierr.New(apierr.Conflict, "there are no open flags on %s", targetID(reviewID, commentID))
+	}
+
+	moderator, err := s.userID(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get user identity: %v", err)
+	}
+
+	var hidden *Hidden
+	if decision == ModerationUphold {
+		now, err := txTime(ctx)
+		if err != nil {
+			return err
+		}
+		hidden = &Hidden{HiddenAt: now, HiddenBy: moderator, Reason: cmp.Or(note, "flags upheld by moderator")}
+	}
+
+	if err := setHidden(ctx, reviewID, commentID, hidden); err != nil {
+		return err
+	}
+
+	if err := clearFlags(ctx, reviewID, commentID); err != nil {
+		return err
+	}
+
+	if err := logModeration(ctx, reviewID, commentID, decision, moderator, note); err != nil {
+		return err
+	}
+
+	return emitEvent(ctx, events.FlagResolved, reviewID, commentID, moderator, []string{"hidden"})
+}
+
+// RestoreContent shows a hidden review, or one of its comments if commentID is provided, again.
+// Like ResolveFlag, it clears the flags and takes the item off the moderation queue, so the old flags
+// can't hide it again. Only moderators may restore content
+func (s *ReviewContract) RestoreContent(ctx contractapi.TransactionContextInterface, reviewID, commentID, note string) error {
+	if err := validateNote(note); err != nil {
+		return err
+	}
+
+	hidden, err := readHidden(ctx, reviewID, commentID)
+	if err != nil {
+		return err
+	}
+	if hidden == nil {
+		return apierr.New(apierr.Conflict, "%s isn't hidden", targetID(reviewID, commentID))
+	}
+
+	moderator, err := s.userID(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get user identity: %v", err)
+	}
+
+	if err := setHidden(ctx, reviewID, commentID, nil); err != nil {
+		return err
+	}
+	if err := clearFlags(ctx, reviewID, commentID); err != nil {
+		return err
+	}
+
+	if err := logModeration(ctx, reviewID, commentID, ModerationRestore, moderator, note); err != nil {
+		return err
+	}
+
+	return emitEvent(ctx, events.ContentRestored, reviewID, commentID, moderator, []string{"hidden"})
+}
+
+// GetModerationLog returns every moderation action taken on a review and its comments, oldest first
+func (s *ReviewContract) GetModerationLog(ctx contractapi.TransactionContextInterface, reviewID string) ([]ModerationAction, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(moderationLogObjectType, []string{reviewID})
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if closeErr := resultsIterator.Close(); closeErr != nil {
+			err = closeErr
+		}
+	}()
+
+	actions := []ModerationAction{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var action ModerationAction
+		if err := decodeDocument(moderationLogObjectType, queryResponse.Value, &action); err != nil {
+			return nil, err
+		}
+		actions = append(actions, action)
+	}
+
+	// keyed by transaction ID, so sort by time
+	slices.SortStableFunc(actions, func(a, b ModerationAction) int {
+		return a.At.Compare(b.At)
+	})
+
+	return actions, nil
+}
+
+// flag records the caller's flag on a review or comment, and hides it once the flag threshold is reached
+func (s *ReviewContract) flag(ctx contractapi.TransactionContextInterface, reviewID, commentID string, reason FlagReason) error {
+	_, err := ulid.ParseStrict(reviewID)
+	if err != nil {
+		return apierr.New(apierr.InvalidArgument, "reviewID isn't ULID %v", err)
+	}
+
+	if !slices.Contains(flagReasons, reason) {
+		return apierr.New(apierr.InvalidArgument, "invalid flag reason: must be one of %v", flagReasons)
+	}
+
+	userID, err := s.authorID(ctx, reviewID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.readActiveReview(ctx, reviewID); err != nil {
+		return err
+	}
+	if commentID != "" {
+		comment, err := readComment(ctx, reviewID, commentID)
+		if err != nil {
+			return err
+		}
+		if comment == nil {
+			return apierr.New(apierr.NotFound, "comment with ID %s not found in review %s", commentID, reviewID)
+		}
+	}
+
+	target := targetID(reviewID, commentID)
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	flagKey, err := ctx.GetStub().CreateCompositeKey(flagObjectType, []string{reviewID, target, userID})
+	if err != nil {
+		return err
+	}
+	flagJSON, err := json.Marshal(Flag{UserID: userID, Reason: reason, FlaggedAt: now, DocType: flagObjectType, SchemaVersion: schemaVersion})
+	if err != nil {
+		return fmt.Errorf("failed to marshal flag: %v", err)
+	}
+	if err := ctx.GetStub().PutState(flagKey, flagJSON); err != nil {
+		return fmt.Errorf("failed to write flag: %v", err)
+	}
+
+	queueKey, err := ctx.GetStub().CreateCompositeKey(flagQueueObjectType, []string{reviewID, target})
+	if err != nil {
+		return err
+	}
+	queueJSON, err := json.Marshal(FlaggedItem{ReviewID: reviewID, CommentID: commentID, DocType: flagQueueObjectType, SchemaVersion: schemaVersion})
+	if err != nil {
+		return fmt.Errorf("failed to marshal flagged item: %v", err)
+	}
+	if err := ctx.GetStub().PutState(queueKey, queueJSON); err != nil {
+		return fmt.Errorf("failed to add to moderation queue: %v", err)
+	}
+
+	// the flag written above isn't visible to reads within this transaction, so it's counted by hand
+	flags, err := readFlags(ctx, reviewID, commentID)
+	if err != nil {
+		return err
+	}
+	count := 1
+	for _, f := range flags {
+		if f.UserID != userID {
+			count++
+		}
+	}
+
+	config, err := readConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	name := events.ReviewFlagged
+	if commentID != "" {
+		name = events.CommentFlagged
+	}
+
+	hidden, err := readHidden(ctx, reviewID, commentID)
+	if err != nil {
+		return err
+	}
+	if hidden != nil || count < config.FlagThreshold {
+		return emitEvent(ctx, name, reviewID, commentID, userID, nil)
+	}
+
+	hidden = &Hidden{HiddenAt: now, Reason: fmt.Sprintf("flagged by %d users", count)}
+	if err := setHidden(ctx, reviewID, commentID, hidden); err != nil {
+		return err
+	}
+	if err := logModeration(ctx, reviewID, commentID, ModerationAutoHide, "", hidden.Reason); err != nil {
+		return err
+	}
+
+	return emitEvent(ctx, name, reviewID, commentID, userID, []string{"hidden"})
+}
+
+// readFlags returns the flags raised against a review, or one of its comments if commentID is set
+func readFlags(ctx contractapi.TransactionContextInterface, reviewID, commentID string) ([]Flag, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(flagObjectType, []string{reviewID, targetID(reviewID, commentID)})
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if closeErr := resultsIterator.Close(); closeErr != nil {
+			err = closeErr
+		}
+	}()
+
+	flags := []Flag{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var flag Flag
+		if err := decodeDocument(flagObjectType, queryResponse.Value, &flag); err != nil {
+			return nil, err
+		}
+		flags = append(flags, flag)
+	}
+
+	return flags, nil
+}
+
+// readHidden returns why a review, or one of its comments if commentID is set, is hidden. nil if it isn't
+func readHidden(ctx contractapi.TransactionContextInterface, reviewID, commentID string) (*Hidden, error) {
+	if commentID != "" {
+		comment, err := readComment(ctx, reviewID, commentID)
+		if err != nil {
+			return nil, err
+		}
+		if comment == nil {
+			return nil, apierr.New(apierr.NotFound, "comment with ID %s not found in review %s", commentID, reviewID)
+		}
+		return comment.Hidden, nil
+	}
+
+	reviewJSON, err := getReviewState(ctx, reviewID)
+	if err != nil {
+		return nil, err
+	}
+	if reviewJSON == nil {
+		return nil, apierr.New(apierr.NotFound, "the review %s does not exist", reviewID)
+	}
+
+	var review Review
+	if err := decodeReview(reviewJSON, &review); err != nil {
+		return nil, err
+	}
+
+	return review.Hidden, nil
+}
+
+// setHidden hides a review, or one of its comments if commentID is set. A nil hidden shows it again
+func setHidden(ctx contractapi.TransactionContextInterface, reviewID, commentID string, hidden *Hidden) error {
+	if commentID != "" {
+		comment, err := readComment(ctx, reviewID, commentID)
+		if err != nil {
+			return err
+		}
+		if comment == nil {
+			return apierr.New(apierr.NotFound, "comment with ID %s not found in review %s", commentID, reviewID)
+		}
+		comment.Hidden = hidden
+		return putComment(ctx, reviewID, *comment)
+	}
+
+	reviewJSON, err := getReviewState(ctx, reviewID)
+	if err != nil {
+		return err
+	}
+	if reviewJSON == nil {
+		return apierr.New(apierr.NotFound, "the review %s does not exist", reviewID)
+	}
+
+	var review Review
+	if err := decodeReview(reviewJSON, &review); err != nil {
+		return err
+	}
+	before := review
+	review.Hidden = hidden
+
+	if err := putReview(ctx, &review); err != nil {
+		return err
+	}
+
+	return recordStats(ctx, &before, &review)
+}
+
+// clearFlags removes the flags raised against a review, or one of its comments if commentID is set,
+// and its entry in the moderation queue
+func clearFlags(ctx contractapi.TransactionContextInterface, reviewID, commentID string) error {
+	target := targetID(reviewID, commentID)
+	if err := deleteByPartialCompositeKey(ctx, flagObjectType, reviewID, target); err != nil {
+		return fmt.Errorf("failed to clear flags: %v", err)
+	}
+
+	queueKey, err := ctx.GetStub().CreateCompositeKey(flagQueueObjectType, []string{reviewID, target})
+	if err != nil {
+		return err
+	}
+	if err := ctx.GetStub().DelState(queueKey); err != nil {
+		return fmt.Errorf("failed to remove from moderation queue: %v", err)
+	}
+
+	return nil
+}
+
+// validateNote validates the optional note a moderator gives with a decision
+func validateNote(note string) error {
+	if note == "" {
+		return nil
+	}
+	return validateText("note", note, noteRule)
+}
+
+// logModeration appends an action to the moderation log of a review
+func logModeration(ctx contractapi.TransactionContextInterface, reviewID, commentID, action, moderator, note string) error {
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	txID := ctx.GetStub().GetTxID()
+	key, err := ctx.GetStub().CreateCompositeKey(moderationLogObjectType, []string{reviewID, txID})
+	if err != nil {
+		return err
+	}
+
+	actionJSON, err := json.Marshal(ModerationAction{
+		TxID:          txID,
+		ReviewID:      reviewID,
+		CommentID:     commentID,
+		Action:        action,
+		Moderator:     moderator,
+		Note:          note,
+		At:            now,
+		DocType:       moderationLogObjectType,
+		SchemaVersion: schemaVersion,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal moderation action: %v", err)
+	}
+
+	return ctx.GetStub().PutState(key, actionJSON)
+}
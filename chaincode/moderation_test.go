@@ -0,0 +1,141 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/edgeflare/fabreview/apierr"
+)
+
+var dave = identity{mspID: "Org2MSP", cn: "dave"}
+
+// flagUntilHidden has as many users flag a review as the default threshold requires to hide it
+func (s *testStub) flagUntilHidden(reviewID string) {
+	s.t.Helper()
+
+	for _, user := range []identity{bob, carol, dave} {
+		s.mustInvoke(user, "FlagReview", reviewID, string(FlagSpam))
+	}
+	if s.readReview(reviewID).Hidden == nil {
+		s.t.Fatal("the review isn't hidden after reaching the flag threshold")
+	}
+}
+
+// flaggedItems returns the first page of the moderation queue
+func (s *testStub) flaggedItems() []FlaggedItem {
+	s.t.Helper()
+
+	var page PaginatedFlaggedItems
+	s.mustInvokeJSON(&page, moderator, "ListFlagged", "10", "")
+	return page.Records
+}
+
+func TestFlagThreshold(t *testing.T) {
+	s := newTestStub(t)
+	id := s.createReview(alice, "example.com")
+
+	s.wantError(apierr.InvalidArgument, bob, "FlagReview", id, "boring")
+	s.mustInvoke(bob, "FlagReview", id, string(FlagSpam))
+	s.mustInvoke(bob, "FlagReview", id, string(FlagAbuse)) // replaces bob's flag
+	s.mustInvoke(carol, "FlagReview", id, string(FlagSpam))
+	if review := s.readReview(id); review.Hidden != nil {
+		t.Fatalf("hidden by %+v below the threshold", review.Hidden)
+	}
+
+	items := s.flaggedItems()
+	if len(items) != 1 || items[0].ReviewID != id || len(items[0].Flags) != 2 || items[0].Hidden != nil {
+		t.Fatalf("got queue %+v", items)
+	}
+	s.wantError(apierr.Forbidden, alice, "ListFlagged", "10", "")
+
+	s.mustInvoke(dave, "FlagReview", id, string(FlagSpam))
+	if review := s.readReview(id); review.Hidden == nil || review.Title != "" {
+		t.Errorf("got %+v, want a redacted hidden review", review)
+	}
+
+	var log []ModerationAction
+	s.mustInvokeJSON(&log, reader, "GetModerationLog", id)
+	if len(log) != 1 || log[0].Action != ModerationAutoHide || log[0].Moderator != "" {
+		t.Errorf("got log %+v", log)
+	}
+}
+
+func TestResolveFlag(t *testing.T) {
+	s := newTestStub(t)
+	dismissed := s.createReview(alice, "a.example")
+	upheld := s.createReview(alice, "b.example")
+	s.flagUntilHidden(dismissed)
+	s.flagUntilHidden(upheld)
+
+	s.wantError(apierr.Forbidden, alice, "ResolveFlag", dismissed, "", ModerationDismiss, "")
+	s.wantError(apierr.InvalidArgument, moderator, "ResolveFlag", dismissed, "", "ignore", "")
+	s.mustInvoke(moderator, "ResolveFlag", dismissed, "", ModerationDismiss, "Honest review")
+	s.mustInvoke(moderator, "ResolveFlag", upheld, "", ModerationUphold, "")
+	s.wantError(apierr.Conflict, moderator, "ResolveFlag", upheld, "", ModerationUphold, "")
+
+	if review := s.readReview(dismissed); review.Hidden != nil {
+		t.Errorf("dismissed review still hidden by %+v", review.Hidden)
+	}
+	if review := s.readReview(upheld); review.Hidden == nil || review.Hidden.HiddenBy == "" {
+		t.Errorf("got upheld review %+v, hidden by the moderator", review)
+	}
+	if items := s.flaggedItems(); len(items) != 0 {
+		t.Errorf("resolved items left in the queue: %+v", items)
+	}
+	if n := s.countKeys(flagObjectType); n != 0 {
+		t.Errorf("%d flags left after resolving", n)
+	}
+}
+
+func TestRestoreContent(t *testing.T) {
+	s := newTestStub(t)
+	id := s.createReview(alice, "example.com")
+	s.flagUntilHidden(id)
+
+	s.wantError(apierr.Forbidden, alice, "RestoreContent", id, "", "")
+	s.mustInvoke(moderator, "RestoreContent", id, "", "Flagged in bad faith")
+	if review := s.readReview(id); review.Hidden != nil {
+		t.Fatalf("still hidden by %+v", review.Hidden)
+	}
+	if items := s.flaggedItems(); len(items) != 0 {
+		t.Errorf("restored review left in the queue: %+v", items)
+	}
+	if n := s.countKeys(flagObjectType, id); n != 0 {
+		t.Errorf("%d flags left after restoring", n)
+	}
+
+	// the old flags don't count towards hiding it again
+	s.mustInvoke(bob, "FlagReview", id, string(FlagSpam))
+	if review := s.readReview(id); review.Hidden != nil {
+		t.Errorf("hidden again by a single flag: %+v", review.Hidden)
+	}
+
+	var log []ModerationAction
+	s.mustInvokeJSON(&log, reader, "GetModerationLog", id)
+	if len(log) != 2 || log[1].Action != ModerationRestore || log[1].Note != "Flagged in bad faith" {
+		t.Errorf("got log %+v", log)
+	}
+	s.wantError(apierr.Conflict, moderator, "RestoreContent", id, "", "")
+}
+
+func TestFlagComment(t *testing.T) {
+	s := newTestStub(t)
+	id := s.createReview(alice, "example.com")
+	commentID := s.newID()
+	s.mustInvoke(bob, "AddComment", id, commentID, "Off topic.")
+
+	for _, user := range []identity{alice, carol, dave} {
+		s.mustInvoke(user, "FlagComment", id, commentID, string(FlagAbuse))
+	}
+	review := s.readReview(id)
+	if len(review.Comments) != 1 || review.Comments[0].Hidden == nil {
+		t.Fatalf("got comments %+v, want the comment hidden", review.Comments)
+	}
+	if review.Hidden != nil {
+		t.Error("flagging a comment hid the review")
+	}
+
+	s.mustInvoke(moderator, "RestoreContent", id, commentID, "")
+	if items := s.flaggedItems(); len(items) != 0 {
+		t.Errorf("restored comment left in the queue: %+v", items)
+	}
+}
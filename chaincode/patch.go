@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/edgeflare/fabreview/apierr"
+	"github.com/edgeflare/fabreview/events"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// mergePatchFields lists the review fields a merge patch may change, and whether they may be cleared
+var mergePatchFields = map[string]bool{
+	"title":      false,
+	"website":    false,
+	"summary":    false,
+	"rating":     false,
+	"country":    false,
+	"state":      true,
+	"locality":   true,
+	"positives":  true,
+	"negatives":  true,
+	"extra_info": true,
+}
+
+// PatchResult is the outcome of a merge patch: the review as stored, and the names of the fields that changed
+type PatchResult struct {
+	Review  *Review  `json:"review"`
+	Changed []string `json:"changed"`
+}
+
+// MergePatchReview applies patch, a JSON Merge Patch (RFC 7396), to a review: a field set to null is cleared,
+// a missing field is kept and any other value replaces the current one. Objects, ie extra_info, are merged
+// key by key the same way. Private details, contact details included, are patched in the transient map
+// under review_private and merged into the stored ones likewise.
+func (s *ReviewContract) MergePatchReview(ctx contractapi.TransactionContextInterface, id, patch string) (*PatchResult, error) {
+	existingReview, err := s.verifyExistsAndOwner(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var patchFields map[string]any
+	if err := json.Unmarshal([]byte(patch), &patchFields); err != nil {
+		return nil, apierr.New(apierr.InvalidArgument, "patch must be a JSON object: %v", err)
+	}
+	for field, value := range patchFields {
+		// contact details left public by earlier versions are moved to the collection on any patch
+		if field == "email" || field == "phone" {
+			return nil, apierr.New(apierr.InvalidArgument, "%s is private, patch it in the transient map under %s", field, transientPrivateKey)
+		}
+		clearable, ok := mergePatchFields[field]
+		if !ok {
+			return nil, apierr.New(apierr.InvalidArgument, "field %q cannot be patched", field)
+		}
+		if !clearable && (value == nil || value == "") {
+			return nil, apierr.New(apierr.InvalidArgument, "field %q cannot be cleared", field)
+		}
+	}
+
+	if extraInfo, ok := patchFields["extra_info"].(map[string]any); ok {
+		added := map[string]string{}
+		for key, value := range extraInfo {
+			if value != nil {
+				added[key] = ""
+			}
+		}
+		if err := assertNoPrivateExtraInfo(ctx, added); err != nil {
+			return nil, err
+		}
+	}
+
+	existingFields, err := toFieldMap(existingReview)
+	if err != nil {
+		return nil, err
+	}
+	mergedJSON, err := json.Marshal(mergePatch(existingFields, patchFields))
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal patched review: %v", err)
+	}
+	var merged Review
+	if err := json.Unmarshal(mergedJSON, &merged); err != nil {
+		return nil, apierr.New(apierr.InvalidArgument, "invalid patch: %v", err)
+	}
+
+	input := &ReviewInput{
+		ID:        id,
+		Title:     merged.Title,
+		Website:   merged.Website,
+		Summary:   merged.Summary,
+		Rating:    merged.Rating,
+		Country:   merged.Country,
+		State:     merged.State,
+		Locality:  merged.Locality,
+		Positives: merged.Positives,
+		Negatives: merged.Negatives,
+		ExtraInfo: merged.ExtraInfo,
+	}
+	// the merged review is complete, so it's validated as on create
+	if err := s.validateInput(input, true); err != nil {
+		return nil, err
+	}
+
+	updatedReview := *existingReview
+	updatedReview.Title = input.Title
+	updatedReview.Website = input.Website
+	updatedReview.Summary = input.Summary
+	updatedReview.Rating = input.Rating
+	updatedReview.Country = input.Country
+	updatedReview.State = input.State
+	updatedReview.Locality = input.Locality
+	updatedReview.Positives = input.Positives
+	updatedReview.Negatives = input.Negatives
+	updatedReview.ExtraInfo = input.ExtraInfo
+	if err := linkEntity(ctx, &updatedReview); err != nil {
+		return nil, err
+	}
+
+	if err := mergePatchPrivate(ctx, &updatedReview); err != nil {
+		return nil, err
+	}
+	if err := touchReview(ctx, &updatedReview); err != nil {
+		return nil, err
+	}
+
+	if err := putReview(ctx, &updatedReview); err != nil {
+		return nil, err
+	}
+
+	if err := recordStats(ctx, existingReview, &updatedReview); err != nil {
+		return nil, err
+	}
+	if err := recordCounts(ctx, existingReview, &updatedReview); err != nil {
+		return nil, err
+	}
+
+	changed, err := changedFields(existingReview, &updatedReview)
+	if err != nil {
+		return nil, err
+	}
+	if err := emitEvent(ctx, events.ReviewUpdated, id, "", updatedReview.UserID, changed); err != nil {
+		return nil, err
+	}
+
+	return &PatchResult{Review: &updatedReview, Changed: changed}, nil
+}
+
+// mergePatchPrivate merges the merge patch passed in the transient map into the private details of review.
+// Contact details earlier versions stored publicly in review are moved into them first
+func mergePatchPrivate(ctx contractapi.TransactionContextInterface, review *Review) error {
+	transient, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return fmt.Errorf("failed to read transient map: %v", err)
+	}
+
+	var patch map[string]any
+	if patchJSON, ok := transient[transientPrivateKey]; ok {
+		if err := json.Unmarshal(patchJSON, &patch); err != nil {
+			return apierr.New(apierr.InvalidArgument, "%s must be a JSON object: %v", transientPrivateKey, err)
+		}
+	}
+
+	contact := contactPatch(review.Email, review.Phone)
+	review.Email, review.Phone = "", ""
+
+	return mergePrivate(ctx, review, contact, patch)
+}
+
+// mergePatch applies patch to target following RFC 7396
+func mergePatch(target, patch any) any {
+	patchObject, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+
+	targetObject, ok := target.(map[string]any)
+	if !ok {
+		targetObject = map[string]any{}
+	}
+
+	result := make(map[string]any, len(targetObject))
+	for key, value := range targetObject {
+		result[key] = value
+	}
+	for key, value := range patchObject {
+		if value == nil {
+			delete(result, key)
+			continue
+		}
+		result[key] = mergePatch(result[key], value)
+	}
+
+	return result
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/edgeflare/fabreview/apierr"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// seedPublicContact has author create a review of website, and adds contact details to it in the open,
+// as earlier versions kept them
+func (s *testStub) seedPublicContact(author identity, website string) string {
+	s.t.Helper()
+
+	id := s.createReview(author, website)
+	key, err := shim.CreateCompositeKey(reviewObjectType, []string{id})
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	var review map[string]any
+	if err := json.Unmarshal(s.State[key], &review); err != nil {
+		s.t.Fatal(err)
+	}
+	review["email"], review["phone"] = "hr@example.com", "+8801712345678"
+	s.seed(review, reviewObjectType, id)
+	return id
+}
+
+func TestMergePatch(t *testing.T) {
+	// from the examples of RFC 7396, appendix A
+	tests := []struct{ target, patch, want string }{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`{"e":null}`, `{"a":1}`, `{"a":1,"e":null}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+	decode := func(s string) any {
+		var v any
+		if err := json.Unmarshal([]byte(s), &v); err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	for _, tt := range tests {
+		if got := mergePatch(decode(tt.target), decode(tt.patch)); !reflect.DeepEqual(got, decode(tt.want)) {
+			t.Errorf("merging %s into %s: got %v, want %s", tt.patch, tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestMergePatchReview(t *testing.T) {
+	s := newTestStub(t)
+	input := s.reviewInput("example.com")
+	input.State, input.Positives, input.ExtraInfo = "Dhaka", []string{"pay"}, map[string]string{"team": "QA", "floor": "3"}
+	s.mustInvoke(alice, "CreateReviewV2", toJSON(t, input))
+
+	var result PatchResult
+	s.mustInvokeJSON(&result, alice, "MergePatchReview", input.ID, `{"positives":null,"state":null,"extra_info":{"floor":null},"rating":9}`)
+	review := s.readReview(input.ID)
+	if len(review.Positives) != 0 || review.State != "" || review.Rating != 9 || review.Title != input.Title ||
+		!reflect.DeepEqual(review.ExtraInfo, map[string]string{"team": "QA"}) {
+		t.Errorf("got %+v", review)
+	}
+	// the returned document is the one stored, without the votes and comments kept under their own keys
+	if result.Review.Revision != review.Revision || !reflect.DeepEqual(result.Review.ExtraInfo, review.ExtraInfo) {
+		t.Errorf("returned %+v, stored %+v", result.Review, review)
+	}
+	for _, field := range []string{"positives", "state", "extra_info", "rating"} {
+		if !slices.Contains(result.Changed, field) {
+			t.Errorf("changed %v lacks %s", result.Changed, field)
+		}
+	}
+	if slices.Contains(result.Changed, "title") {
+		t.Errorf("changed %v includes the kept title", result.Changed)
+	}
+
+	for _, patch := range []string{`{"title":null}`, `{"user_id":"someone"}`, `{"email":"hr@example.com"}`, `{"phone":null}`, `{"extra_info":{"email":"hr@example.com"}}`, `[]`, `{"rating":11}`} {
+		s.wantError(apierr.InvalidArgument, alice, "MergePatchReview", input.ID, patch)
+	}
+	s.wantError(apierr.Forbidden, bob, "MergePatchReview", input.ID, `{"rating":1}`)
+}
+
+func TestMergePatchReviewPrivate(t *testing.T) {
+	s := newTestStub(t)
+	input := s.reviewInput("example.com")
+	private := ReviewPrivate{Email: "hr@example.com", Phone: "+8801712345678", Salt: "0123456789abcdef"}
+	s.mustInvokeTransient(alice, map[string][]byte{transientPrivateKey: []byte(toJSON(t, private))}, "CreateReviewV2", toJSON(t, input))
+
+	s.mustInvokeTransient(alice, map[string][]byte{transientPrivateKey: []byte(`{"phone":null}`)}, "MergePatchReview", input.ID, `{}`)
+	var got ReviewPrivate
+	s.mustInvokeJSON(&got, reader, "ReadReviewPrivate", input.ID)
+	if got.Email != private.Email || got.Phone != "" || got.Salt != private.Salt {
+		t.Errorf("got %+v, want the phone cleared", got)
+	}
+}
+
+func TestPatchesMovePublicContact(t *testing.T) {
+	s := newTestStub(t)
+
+	for _, patch := range []func(id string){
+		func(id string) { s.mustInvoke(alice, "MergePatchReview", id, `{"rating":3}`) },
+		func(id string) { s.mustInvoke(alice, "PatchReview", toJSON(t, ReviewPatch{ID: id, Rating: 3})) },
+	} {
+		id := s.seedPublicContact(alice, "example.com")
+		patch(id)
+		txID := fmt.Sprintf("%064x", s.txCount)
+
+		if review := s.readReview(id); review.Email != "" || review.Phone != "" || review.Rating != 3 {
+			t.Errorf("got %+v, want the contact details moved", review)
+		}
+		var got ReviewPrivate
+		s.mustInvokeJSON(&got, reader, "ReadReviewPrivate", id)
+		if got.Email != "hr@example.com" || got.Phone != "+8801712345678" {
+			t.Errorf("got %+v", got)
+		}
+		// the salt mustn't be derivable from the ledger
+		if len(got.Salt) < minSaltLength || got.Salt == txID {
+			t.Errorf("got salt %q", got.Salt)
+		}
+	}
+
+	// a patch of the private details applies over the moved contact details
+	id := s.seedPublicContact(alice, "example.com")
+	s.mustInvokeTransient(alice, map[string][]byte{transientPrivateKey: []byte(`{"phone":null}`)}, "MergePatchReview", id, `{}`)
+	var got ReviewPrivate
+	s.mustInvokeJSON(&got, reader, "ReadReviewPrivate", id)
+	if got.Email != "hr@example.com" || got.Phone != "" {
+		t.Errorf("got %+v, want the phone cleared", got)
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"github.com/edgeflare/fabreview/apierr"
+	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// A reviewer's contact details and sensitive extra info are kept in a private data collection of the
+// author's org, see collections_config.json. They are passed in the transient map, so they never appear
+// in the transaction, and the review document only records the collection and a hash of them.
+const (
+	transientPrivateKey = "review_private" // transient map key the private details are passed under
+	minSaltLength       = 16
+)
+
+// ReviewPrivate holds the details of a review only members of the author's org can read
+type ReviewPrivate struct {
+	ID        string            `json:"id"`
+	Email     string            `json:"email,omitzero" metadata:",optional"`      // an email address
+	Phone     string            `json:"phone,omitzero" metadata:",optional"`      // in E.164 format, eg +8801712345678
+	ExtraInfo map[string]string `json:"extra_info,omitzero" metadata:",optional"` // sensitive extra info
+	// Salt is a random value chosen by the client, so the hash in the review document can't be
+	// matched against guessed details. At least 16 chars. Details the contract stores on its own,
+	// like contact details earlier versions kept public, get one from privateSalt
+	Salt string `json:"salt"`
+	// DocType and SchemaVersion identify the stored document, see schemaVersion
+	DocType       string `json:"doc_type,omitzero" metadata:",optional"`
+	SchemaVersion int    `json:"schema_version,omitzero" metadata:",optional"`
+}
+
+// privateCollection returns the private data collection of the caller's org
+func privateCollection(ctx contractapi.TransactionContextInterface) (string, error) {
+	mspID, err := cid.GetMSPID(ctx.GetStub())
+	if err != nil {
+		return "", fmt.Errorf("failed to get MSP ID: %v", err)
+	}
+	return mspID + "PrivateCollection", nil
+}
+
+// readPrivateInput returns the private details passed in the transient map, nil if there are none
+func readPrivateInput(ctx contractapi.TransactionContextInterface, id string) (*ReviewPrivate, error) {
+	transient, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read transient map: %v", err)
+	}
+	privateJSON, ok := transient[transientPrivateKey]
+	if !ok {
+		return nil, nil
+	}
+
+	var private ReviewPrivate
+	if err := json.Unmarshal(privateJSON, &private); err != nil {
+		return nil, apierr.New(apierr.InvalidArgument, "invalid %s: %v", transientPrivateKey, err)
+	}
+	private.ID = id
+
+	if err := validatePrivate(&private); err != nil {
+		return nil, err
+	}
+
+	return &private, nil
+}
+
+// validatePrivate checks the private details of a review
+func validatePrivate(private *ReviewPrivate) error {
+	v := &validator{}
+	if private.Email != "" {
+		v.text("email", private.Email, emailRule)
+	}
+	if private.Phone != "" {
+		v.text("phone", private.Phone, phoneRule)
+	}
+	v.dict("extra_info", private.ExtraInfo, extraInfoRule)
+	if len(private.Salt) < minSaltLength {
+		v.add("salt", apierr.TooShort, fmt.Sprintf("must be at least %d chars", minSaltLength))
+	}
+
+	return v.err()
+}
+
+// privateSalt derives the salt of private details the contract stores on its own. The transaction ID
+// is public, so it's run through an HMAC keyed by the pseudonym secret, which only member peers hold;
+// every endorser derives the same salt
+func privateSalt(ctx contractapi.TransactionContextInterface, reviewID string) (string, error) {
+	secret, err := readPseudonymSecret(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("salt"))
+	mac.Write([]byte{0})
+	mac.Write([]byte(ctx.GetStub().GetTxID()))
+	mac.Write([]byte{0})
+	mac.Write([]byte(reviewID))
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// withContact sets the contact details passed as arguments to CreateReview and UpdateReview in private,
+// which is created if it's nil, so they're stored in the collection rather than the review
+func withContact(ctx contractapi.TransactionContextInterface, id string, private *ReviewPrivate, email, phone string) (*ReviewPrivate, error) {
+	if !supplied(email) && !supplied(phone) {
+		return private, nil
+	}
+
+	if private == nil {
+		salt, err := privateSalt(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		private = &ReviewPrivate{ID: id, Salt: salt}
+	}
+	if supplied(email) {
+		private.Email = email
+	}
+	if supplied(phone) {
+		private.Phone = phone
+	}
+	if err := validatePrivate(private); err != nil {
+		return nil, err
+	}
+
+	return private, nil
+}
+
+// contactPatch returns a merge patch of private details setting the supplied contact details, nil if neither is
+func contactPatch(email, phone string) map[string]any {
+	patch := map[string]any{}
+	if supplied(email) {
+		patch["email"] = email
+	}
+	if supplied(phone) {
+		patch["phone"] = phone
+	}
+	if len(patch) == 0 {
+		return nil
+	}
+	return patch
+}
+
+// mergePrivate applies patches, JSON merge patches, in turn to the stored private details of review and
+// stores the result. Nil patches are skipped, and without any nothing is written
+func mergePrivate(ctx contractapi.TransactionContextInterface, review *Review, patches ...map[string]any) error {
+	patches = slices.DeleteFunc(patches, func(patch map[string]any) bool { return patch == nil })
+	if len(patches) == 0 {
+		return nil
+	}
+
+	var merged any = map[string]any{}
+	if review.PrivateCollection != "" {
+		existingJSON, err := ctx.GetStub().GetPrivateData(review.PrivateCollection, review.ID)
+		if err != nil {
+			return fmt.Errorf("failed to read from %s: %v", review.PrivateCollection, err)
+		}
+		if existingJSON != nil {
+			existingJSON, err = upgradeDocument(privateDocType, existingJSON)
+			if err != nil {
+				return err
+			}
+			if err := json.Unmarshal(existingJSON, &merged); err != nil {
+				return err
+			}
+		}
+	}
+	for _, patch := range patches {
+		merged = mergePatch(merged, patch)
+	}
+
+	mergedJSON, err := json.Marshal(merged)
+	if err != nil {
+		return fmt.Errorf("failed to marshal private details: %v", err)
+	}
+	var private ReviewPrivate
+	if err := json.Unmarshal(mergedJSON, &private); err != nil {
+		return apierr.New(apierr.InvalidArgument, "invalid %s: %v", transientPrivateKey, err)
+	}
+	private.ID = review.ID
+	if private.Salt == "" {
+		if private.Salt, err = privateSalt(ctx, review.ID); err != nil {
+			return err
+		}
+	}
+	if err := validatePrivate(&private); err != nil {
+		return err
+	}
+
+	return putPrivate(ctx, review, &private)
+}
+
+// supplied reports whether a contact detail holds a value
+func supplied(value string) bool {
+	return value != "" && value != NOT_SUPPLIED
+}
+
+// assertNoPrivateExtraInfo refuses sensitive extra info passed as plain arguments,
+// which would be written to the ledger as part of the transaction
+func assertNoPrivateExtraInfo(ctx contractapi.TransactionContextInterface, extraInfo map[string]string) error {
+	if len(extraInfo) == 0 {
+		return nil
+	}
+
+	config, err := readConfig(ctx)
+	if err != nil {
+		return err
+	}
+	for key := range extraInfo {
+		if slices.Contains(config.PrivateExtraInfoKeys, key) {
+			return apierr.New(apierr.InvalidArgument, "extra info %s is private, pass it in the transient map under %s", key, transientPrivateKey)
+		}
+	}
+
+	return nil
+}
+
+// putPrivate stores the private details of a review in the caller's org collection,
+// and records the collection and the hash of the details in the review
+func putPrivate(ctx contractapi.TransactionContextInterface, review *Review, private *ReviewPrivate) error {
+	collection := review.PrivateCollection
+	if collection == "" {
+		var err error
+		collection, err = privateCollection(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	private.DocType, private.SchemaVersion = privateDocType, schemaVersion
+	privateJSON, err := json.Marshal(private)
+	if err != nil {
+		return fmt.Errorf("failed to marshal private details: %v", err)
+	}
+	if err := ctx.GetStub().PutPrivateData(collection, review.ID, privateJSON); err != nil {
+		return fmt.Errorf("failed to write private details to %s: %v", collection, err)
+	}
+
+	hash := sha256.Sum256(privateJSON)
+	review.PrivateCollection = collection
+	review.PrivateHash = hex.EncodeToString(hash[:])
+
+	return nil
+}
+
+// ReadReviewPrivate returns the contact details and sensitive extra info of a review.
+// Only members of the author's org, which owns the collection they're kept in, can read them
+func (s *ReviewContract) ReadReviewPrivate(ctx contractapi.TransactionContextInterface, id string) (*ReviewPrivate, error) {
+	review, err := s.readReview(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if review.PrivateCollection == "" {
+		return nil, apierr.New(apierr.NotFound, "the review %s has no private details", id)
+	}
+
+	collection, err := privateCollection(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if collection != review.PrivateCollection {
+		return nil, apierr.New(apierr.Forbidden, "unauthorized: only members of %s can read the private details of review %s", review.PrivateCollection, id)
+	}
+
+	privateJSON, err := ctx.GetStub().GetPrivateData(collection, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from %s: %v", collection, err)
+	}
+	if privateJSON == nil {
+		return nil, apierr.New(apierr.NotFound, "the private details of review %s aren't available on this peer", id)
+	}
+
+	hash := sha256.Sum256(privateJSON)
+	if hex.EncodeToString(hash[:]) != review.PrivateHash {
+		return nil, fmt.Errorf("the private details of review %s don't match their hash", id)
+	}
+
+	var private ReviewPrivate
+	if err := decodeDocument(privateDocType, privateJSON, &private); err != nil {
+		return nil, err
+	}
+
+	return &private, nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/edgeflare/fabreview/apierr"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// reviewInput returns a valid new review of website
+func (s *testStub) reviewInput(website string) ReviewInput {
+	return ReviewInput{ID: s.newID(), Title: "Review of " + website, Website: website, Summary: "A summary long enough to pass validation.", Rating: 7, Country: "BD"}
+}
+
+func TestReviewPrivate(t *testing.T) {
+	s := newTestStub(t)
+	input := s.reviewInput("example.com")
+	private := ReviewPrivate{Email: "hr@example.com", Phone: "+8801712345678", ExtraInfo: map[string]string{"name": "A. Manager"}, Salt: "0123456789abcdef"}
+	s.mustInvokeTransient(alice, map[string][]byte{transientPrivateKey: []byte(toJSON(t, private))}, "CreateReviewV2", toJSON(t, input))
+
+	review := s.readReview(input.ID)
+	if review.PrivateCollection != "Org1MSPPrivateCollection" || len(review.PrivateHash) != 64 {
+		t.Errorf("got collection %q and hash %q", review.PrivateCollection, review.PrivateHash)
+	}
+
+	var got ReviewPrivate
+	s.mustInvokeJSON(&got, reader, "ReadReviewPrivate", input.ID)
+	if got.Email != private.Email || got.Phone != private.Phone || got.ExtraInfo["name"] != "A. Manager" || got.ID != input.ID {
+		t.Errorf("got %+v", got)
+	}
+	s.wantError(apierr.Forbidden, carol, "ReadReviewPrivate", input.ID)
+}
+
+func TestReviewPrivateRejected(t *testing.T) {
+	s := newTestStub(t)
+
+	input := s.reviewInput("example.com")
+	input.ExtraInfo = map[string]string{"email": "hr@example.com"}
+	s.wantError(apierr.InvalidArgument, alice, "CreateReviewV2", toJSON(t, input))
+
+	for _, private := range []ReviewPrivate{
+		{Email: "hr@example.com", Salt: "short"},
+		{Email: "not an email", Salt: "0123456789abcdef"},
+		{Phone: "01712345678", Salt: "0123456789abcdef"},
+	} {
+		transient := map[string][]byte{transientPrivateKey: []byte(toJSON(t, private))}
+		if response := s.invokeTransient(alice, transient, "CreateReviewV2", toJSON(t, s.reviewInput("example.com"))); response.Status == shim.OK {
+			t.Errorf("accepted private details %+v", private)
+		}
+	}
+
+	s.wantError(apierr.NotFound, reader, "ReadReviewPrivate", s.createReview(alice, "example.net"))
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/edgeflare/fabreview/apierr"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Authors are recorded under a pseudonym that differs per review, so the world state doesn't reveal
+// which reviews, comments and votes come from the same person. The pseudonym is an HMAC of the review ID
+// and user ID, keyed by a secret only the member orgs' peers hold, in a private data collection.
+// Within a review the pseudonym is stable, which is what ownership checks compare against.
+const (
+	secretCollection   = "fabreviewSecrets" // see collections_config.json
+	pseudonymSecretKey = "pseudonym_secret"
+	transientSecretKey = "secret" // transient map key SetPseudonymSecret reads the secret from
+	minSecretLength    = 32
+)
+
+// pseudonym returns the author handle of userID on the review with reviewID
+func pseudonym(secret []byte, reviewID, userID string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(reviewID))
+	mac.Write([]byte{0})
+	mac.Write([]byte(userID))
+	return "anon-" + hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// readPseudonymSecret returns the secret pseudonyms are derived from
+func readPseudonymSecret(ctx contractapi.TransactionContextInterface) ([]byte, error) {
+	secret, err := ctx.GetStub().GetPrivateData(secretCollection, pseudonymSecretKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pseudonym secret: %v", err)
+	}
+	if secret == nil {
+		return nil, apierr.New(apierr.Conflict, "the pseudonym secret hasn't been set, see SetPseudonymSecret")
+	}
+	return secret, nil
+}
+
+// authorID returns the caller's pseudonym on the review with reviewID
+func (s *ReviewContract) authorID(ctx contractapi.TransactionContextInterface, reviewID string) (string, error) {
+	userID, err := s.userID(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to get user identity: %v", err)
+	}
+
+	secret, err := readPseudonymSecret(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return pseudonym(secret, reviewID, userID), nil
+}
+
+// SetPseudonymSecret stores the secret pseudonyms are derived from, passed in the transient map under "secret"
+// so it never reaches the ledger. It can be set once only: changing it would disown every author.
+func (s *ReviewContract) SetPseudonymSecret(ctx contractapi.TransactionContextInterface) error {
+	existing, err := ctx.GetStub().GetPrivateData(secretCollection, pseudonymSecretKey)
+	if err != nil {
+		return fmt.Errorf("failed to read pseudonym secret: %v", err)
+	}
+	if existing != nil {
+		return apierr.New(apierr.AlreadyExists, "the pseudonym secret has already been set")
+	}
+
+	transient, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return fmt.Errorf("failed to read transient map: %v", err)
+	}
+	secret := transient[transientSecretKey]
+	if len(secret) < minSecretLength {
+		return apierr.New(apierr.InvalidArgument, "the secret must be passed in the transient map under %q and be at least %d bytes", transientSecretKey, minSecretLength)
+	}
+
+	return ctx.GetStub().PutPrivateData(secretCollection, pseudonymSecretKey, secret)
+}
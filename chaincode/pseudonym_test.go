@@ -0,0 +1,62 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/edgeflare/fabreview/apierr"
+)
+
+func TestPseudonym(t *testing.T) {
+	secret := []byte(testSecret)
+	handle := pseudonym(secret, "review-1", "x509::Org1MSP::alice")
+
+	if !strings.HasPrefix(handle, "anon-") || strings.Contains(handle, "alice") {
+		t.Errorf("got %q", handle)
+	}
+	if pseudonym(secret, "review-1", "x509::Org1MSP::alice") != handle {
+		t.Error("the pseudonym isn't stable within a review")
+	}
+	for _, other := range []string{
+		pseudonym(secret, "review-2", "x509::Org1MSP::alice"),
+		pseudonym(secret, "review-1", "x509::Org1MSP::bob"),
+		pseudonym([]byte("another secret"), "review-1", "x509::Org1MSP::alice"),
+	} {
+		if other == handle {
+			t.Errorf("%q is shared with another review, user or secret", other)
+		}
+	}
+}
+
+func TestPseudonymousAuthors(t *testing.T) {
+	s := newTestStub(t)
+	first := s.readReview(s.createReview(alice, "a.example"))
+	second := s.readReview(s.createReview(alice, "b.example"))
+
+	if first.UserID == second.UserID {
+		t.Errorf("both reviews of alice are by %s", first.UserID)
+	}
+	for _, review := range []*Review{first, second} {
+		if strings.Contains(review.UserID, "alice") || strings.Contains(review.UserID, "Org1MSP") {
+			t.Errorf("author %s reveals the user", review.UserID)
+		}
+	}
+
+	// ownership checks compare pseudonyms
+	commentID := s.newID()
+	s.mustInvoke(bob, "AddComment", first.ID, commentID, "Agreed.")
+	s.wantError(apierr.Forbidden, alice, "EditComment", first.ID, commentID, "Not alice's.")
+	s.mustInvoke(bob, "EditComment", first.ID, commentID, "Agreed, mostly.")
+	s.wantError(apierr.Forbidden, bob, "PatchReview", toJSON(t, ReviewPatch{ID: first.ID, Title: "Not bob's"}))
+	s.mustInvoke(alice, "PatchReview", toJSON(t, ReviewPatch{ID: first.ID, Title: "Alice's own"}))
+}
+
+func TestSetPseudonymSecret(t *testing.T) {
+	s := newTestStub(t)
+	s.wantError(apierr.AlreadyExists, admin, "SetPseudonymSecret")
+
+	delete(s.PvtState[secretCollection], pseudonymSecretKey)
+	s.wantError(apierr.Conflict, alice, "CreateReviewV2", toJSON(t, ReviewInput{ID: s.newID(), Title: "No secret", Website: "example.com", Summary: "A summary long enough to pass validation.", Rating: 7, Country: "BD"}))
+	s.wantError(apierr.InvalidArgument, admin, "SetPseudonymSecret")
+	s.wantError(apierr.Forbidden, alice, "SetPseudonymSecret")
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/edgeflare/fabreview/apierr"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	// maxFilterInValues caps the number of values accepted by an $in operator
+	maxFilterInValues = 20
+)
+
+// filterOperators lists, per filterable review field, the operators a client may use.
+// Anything not listed here is rejected before a selector is built.
+var filterOperators = map[string][]string{
+	"country":   {"$eq", "$in"},
+	"state":     {"$eq", "$in"},
+	"locality":  {"$eq", "$in"},
+	"website":   {"$eq", "$in"},
+	"rating":    {"$eq", "$gt", "$gte", "$lt", "$lte"},
+	"positives": {"$contains"},
+	"negatives": {"$contains"},
+}
+
+// filterRules holds the rule string values of each filterable field must follow
+var filterRules = map[string]textRule{
+	"country":   countryRule,
+	"state":     regionRule,
+	"locality":  regionRule,
+	"website":   {},
+	"positives": tagRule,
+	"negatives": tagRule,
+}
+
+// QueryReviews returns a page of reviews matching filter, a JSON object keyed by review field.
+// A field maps either to a plain value (equality, or text search for positives/negatives)
+// or to an object of operators, eg
+//
+//	{"country": "BD", "state": {"$in": ["Dhaka", "Sylhet"]}, "rating": {"$gte": 7}, "negatives": "price"}
+//
+// Only the fields and operators in filterOperators are accepted. Like ReadReviewsPage, pages may be short.
+func (s *ReviewContract) QueryReviews(ctx contractapi.TransactionContextInterface, filter string, pageSize int32, bookmark string, includeInteractions bool) (*PaginatedQueryResult, error) {
+	if pageSize < 1 || pageSize > maxPageSize {
+		return nil, apierr.New(apierr.InvalidArgument, "page size must be between 1 and %d", maxPageSize)
+	}
+
+	selector, err := buildReviewSelector(filter)
+	if err != nil {
+		return nil, apierr.New(apierr.InvalidArgument, "invalid filter: %v", err)
+	}
+
+	queryJSON, err := json.Marshal(map[string]any{"selector": selector})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal query: %v", err)
+	}
+
+	resultsIterator, responseMetadata, err := ctx.GetStub().GetQueryResultWithPagination(string(queryJSON), pageSize, bookmark)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if closeErr := resultsIterator.Close(); closeErr != nil {
+			err = closeErr
+		}
+	}()
+
+	results, err := constructQueryResultFromIterator(ctx, resultsIterator, includeInteractions)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PaginatedQueryResult{
+		Records:      results,
+		FetchedCount: int32(len(results)),
+		Bookmark:     responseMetadata.Bookmark,
+	}, nil
+}
+
+// buildReviewSelector validates a filter and translates it into a CouchDB Mango selector
+func buildReviewSelector(filter string) (map[string]any, error) {
+	// rich queries see every document in the namespace, so only reviews are selected.
+	// deleted and moderated reviews are left out
+	selector := map[string]any{
+		"doc_type":  reviewDocType,
+		"tombstone": map[string]any{"$exists": false},
+		"hidden":    map[string]any{"$exists": false},
+	}
+	if strings.TrimSpace(filter) == "" {
+		return selector, nil
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(filter), &fields); err != nil {
+		return nil, fmt.Errorf("filter must be a JSON object: %v", err)
+	}
+
+	for field, raw := range fields {
+		allowed, ok := filterOperators[field]
+		if !ok {
+			return nil, fmt.Errorf("field %q cannot be filtered on", field)
+		}
+
+		// a plain value is shorthand for the field's first operator
+		operators := map[string]json.RawMessage{}
+		if trimmed := strings.TrimSpace(string(raw)); strings.HasPrefix(trimmed, "{") {
+			if err := json.Unmarshal(raw, &operators); err != nil {
+				return nil, fmt.Errorf("invalid operators for %s: %v", field, err)
+			}
+			if len(operators) == 0 {
+				return nil, fmt.Errorf("no operators given for %s", field)
+			}
+		} else {
+			operators[allowed[0]] = raw
+		}
+
+		condition := map[string]any{}
+		for op, value := range operators {
+			if !slices.Contains(allowed, op) {
+				return nil, fmt.Errorf("operator %s is not allowed on %s", op, field)
+			}
+
+			mangoOp, mangoValue, err := filterCondition(field, op, value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", field, err)
+			}
+			condition[mangoOp] = mangoValue
+		}
+
+		selector[field] = condition
+	}
+
+	return selector, nil
+}
+
+// filterCondition checks the value of a single allowlisted operator and returns its Mango equivalent
+func filterCondition(field, op string, raw json.RawMessage) (string, any, error) {
+	switch op {
+	case "$eq":
+		if field == "rating" {
+			rating, err := filterRating(raw)
+			return op, rating, err
+		}
+		value, err := filterString(field, raw)
+		return op, value, err
+
+	case "$gt", "$gte", "$lt", "$lte":
+		rating, err := filterRating(raw)
+		return op, rating, err
+
+	case "$in":
+		var values []json.RawMessage
+		if err := json.Unmarshal(raw, &values); err != nil {
+			return "", nil, fmt.Errorf("$in expects an array of strings")
+		}
+		if len(values) == 0 || len(values) > maxFilterInValues {
+			return "", nil, fmt.Errorf("$in expects between 1 and %d values", maxFilterInValues)
+		}
+		in := make([]string, 0, len(values))
+		for _, v := range values {
+			value, err := filterString(field, v)
+			if err != nil {
+				return "", nil, err
+			}
+			in = append(in, value)
+		}
+		return op, in, nil
+
+	case "$contains":
+		text, err := filterString(field, raw)
+		if err != nil {
+			return "", nil, err
+		}
+		// case-insensitive substring match on any array element. text is quoted so it can't inject a pattern
+		return "$elemMatch", map[string]string{"$regex": "(?i)" + regexp.QuoteMeta(text)}, nil
+	}
+
+	return "", nil, fmt.Errorf("unsupported operator %s", op)
+}
+
+// filterString decodes a string filter value and checks it against the field's rule
+func filterString(field string, raw json.RawMessage) (string, error) {
+	var value string
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return "", fmt.Errorf("expected a string")
+	}
+	if field == "website" {
+		domain, err := canonicalWebsite(value)
+		if err != nil {
+			return "", err
+		}
+		value = domain
+	}
+	if field == "country" {
+		value = strings.ToUpper(value)
+	}
+	if code, message := filterRules[field].check(value); code != "" {
+		return "", fmt.Errorf("%s", message)
+	}
+	return value, nil
+}
+
+// filterRating decodes a rating filter value
+func filterRating(raw json.RawMessage) (uint8, error) {
+	var rating uint8
+	if err := json.Unmarshal(raw, &rating); err != nil {
+		return 0, fmt.Errorf("expected a rating between 1 and 10")
+	}
+	if rating < 1 || rating > 10 {
+		return 0, fmt.Errorf("expected a rating between 1 and 10")
+	}
+	return rating, nil
+}
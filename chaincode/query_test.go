@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/edgeflare/fabreview/apierr"
+)
+
+func TestBuildReviewSelector(t *testing.T) {
+	base := func(fields map[string]any) map[string]any {
+		selector := map[string]any{
+			"doc_type":  reviewDocType,
+			"tombstone": map[string]any{"$exists": false},
+			"hidden":    map[string]any{"$exists": false},
+		}
+		for field, condition := range fields {
+			selector[field] = condition
+		}
+		return selector
+	}
+
+	tests := []struct {
+		name   string
+		filter string
+		want   map[string]any
+	}{
+		{"empty", "", base(nil)},
+		{"blank", "  ", base(nil)},
+		{"plain value", `{"country": "bd"}`, base(map[string]any{"country": map[string]any{"$eq": "BD"}})},
+		{"in", `{"state": {"$in": ["Dhaka", "Sylhet"]}}`, base(map[string]any{"state": map[string]any{"$in": []string{"Dhaka", "Sylhet"}}})},
+		{"rating range", `{"rating": {"$gte": 7, "$lt": 10}}`, base(map[string]any{"rating": map[string]any{"$gte": uint8(7), "$lt": uint8(10)}})},
+		{"canonical website", `{"website": "https://www.Example.com/jobs"}`, base(map[string]any{"website": map[string]any{"$eq": "example.com"}})},
+		{"contains is quoted", `{"negatives": "a.b"}`, base(map[string]any{"negatives": map[string]any{"$elemMatch": map[string]string{"$regex": `(?i)a\.b`}}})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := buildReviewSelector(tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildReviewSelectorRejects(t *testing.T) {
+	filters := []string{
+		`[]`,
+		`{"summary": "x"}`,
+		`{"user_id": "anon-1"}`,
+		`{"country": {"$regex": "."}}`,
+		`{"country": {}}`,
+		`{"rating": 11}`,
+		`{"rating": {"$gt": "7"}}`,
+		`{"state": {"$in": []}}`,
+		`{"state": {"$in": [` + strings.Repeat(`"Dhaka",`, maxFilterInValues) + `"Sylhet"]}}`,
+		`{"country": "Bangladesh"}`,
+	}
+	for _, filter := range filters {
+		if _, err := buildReviewSelector(filter); err == nil {
+			t.Errorf("%s was accepted", filter)
+		}
+	}
+}
+
+func TestQueryReviews(t *testing.T) {
+	s := newTestStub(t)
+	id := s.createReview(alice, "example.com")
+
+	var page PaginatedQueryResult
+	s.mustInvokeJSON(&page, reader, "QueryReviews", `{"rating": {"$gte": 5}}`, "10", "", "false")
+	if len(page.Records) != 1 || page.Records[0].Record.ID != id {
+		t.Errorf("got %+v, want review %s", page.Records, id)
+	}
+
+	var query struct {
+		Selector map[string]any `json:"selector"`
+	}
+	if err := json.Unmarshal([]byte(s.queries[len(s.queries)-1]), &query); err != nil {
+		t.Fatal(err)
+	}
+	if query.Selector["doc_type"] != reviewDocType || query.Selector["rating"] == nil {
+		t.Errorf("ran %s", s.queries[len(s.queries)-1])
+	}
+
+	s.wantError(apierr.InvalidArgument, reader, "QueryReviews", `{"summary": "x"}`, "10", "", "false")
+	s.wantError(apierr.InvalidArgument, reader, "QueryReviews", "", "0", "", "false")
+}
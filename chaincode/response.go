@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/edgeflare/fabreview/apierr"
+	"github.com/edgeflare/fabreview/events"
+	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/oklog/ulid/v2"
+)
+
+// A reviewed entity can answer each review once, with a response pinned above the comments.
+// Posting again replaces it and bumps its version; earlier versions remain in its history.
+const responseObjectType = "response" // response~reviewID
+
+// verifiedDomainsAttribute is the certificate attribute listing, comma separated, the domains a CA admin
+// of an admin MSP verified the holder controls, eg
+//
+//	"attrs": [{"name": "fabreview.verified_domains", "value": "technobd.com", "ecert": true}]
+const verifiedDomainsAttribute = "fabreview.verified_domains"
+
+// OfficialResponse is the answer of the reviewed entity to a review
+type OfficialResponse struct {
+	ReviewID    string    `json:"review_id"`
+	EntityID    string    `json:"entity_id"`
+	Response    string    `json:"response"`     // max 4096 chars
+	ResponderID string    `json:"responder_id"` // user ID of the entity's representative
+	Version     int       `json:"version"`      // 1 when first posted, incremented by each replacement
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	// DocType and SchemaVersion identify the stored document, see schemaVersion
+	DocType       string `json:"doc_type,omitzero" metadata:",optional"`
+	SchemaVersion int    `json:"schema_version,omitzero" metadata:",optional"`
+}
+
+// OfficialResponseVersion is one committed version of an official response
+type OfficialResponseVersion struct {
+	TxID      string            `json:"tx_id"`
+	Timestamp time.Time         `json:"timestamp"`
+	IsDelete  bool              `json:"is_delete"`
+	Record    *OfficialResponse `json:"record,omitzero" metadata:",optional"`  // nil when the version is a deletion
+	Changes   []FieldChange     `json:"changes,omitzero" metadata:",optional"` // against the previous version
+}
+
+// responseKey returns the composite key of the official response to a review
+func responseKey(ctx contractapi.TransactionContextInterface, reviewID string) (string, error) {
+	return ctx.GetStub().CreateCompositeKey(responseObjectType, []string{reviewID})
+}
+
+// readOfficialResponse returns the official response to a review, or nil if there's none
+func readOfficialResponse(ctx contractapi.TransactionContextInterface, reviewID string) (*OfficialResponse, error) {
+	key, err := responseKey(ctx, reviewID)
+	if err != nil {
+		return nil, err
+	}
+
+	responseJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if responseJSON == nil {
+		return nil, nil
+	}
+
+	var response OfficialResponse
+	if err := decodeDocument(responseObjectType, responseJSON, &response); err != nil {
+		return nil, err
+	}
+
+	return &response, nil
+}
+
+// assertControlsEntity refuses callers whose certificate doesn't list a verified domain of the entity.
+// Like roles, verified domains are only trusted from the CAs of the admin MSPs
+func assertControlsEntity(ctx contractapi.TransactionContextInterface, entityID string) error {
+	mspID, err := cid.GetMSPID(ctx.GetStub())
+	if err != nil {
+		return fmt.Errorf("failed to get MSP ID: %v", err)
+	}
+	config, err := readConfig(ctx)
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(config.AdminMSPs, mspID) {
+		return apierr.New(apierr.Forbidden, "unauthorized: %s isn't an admin MSP, so its CA can't verify domains", mspID)
+	}
+
+	value, found, err := cid.GetAttributeValue(ctx.GetStub(), verifiedDomainsAttribute)
+	if err != nil {
+		return fmt.Errorf("failed to read %s attribute: %v", verifiedDomainsAttribute, err)
+	}
+	if found {
+		for _, domain := range strings.Split(value, ",") {
+			domain, err := canonicalWebsite(domain)
+			if err != nil {
+				continue
+			}
+			owner, err := entityIDOf(ctx, domain)
+			if err != nil {
+				return err
+			}
+			if owner == entityID {
+				return nil
+			}
+		}
+	}
+
+	return apierr.New(apierr.Forbidden, "unauthorized: the certificate doesn't list a verified domain of the entity %s in %s", entityID, verifiedDomainsAttribute)
+}
+
+// PostOfficialResponse posts the response of the reviewed entity to a review, replacing the previous one.
+// Only representatives of the entity, see verifiedDomainsAttribute, may respond
+func (s *ReviewContract) PostOfficialResponse(ctx contractapi.TransactionContextInterface, reviewID, response string) error {
+	_, err := ulid.ParseStrict(reviewID)
+	if err != nil {
+		return apierr.New(apierr.InvalidArgument, "reviewID isn't ULID %v", err)
+	}
+
+	if err := validateText("response", response, commentRule); err != nil {
+		return err
+	}
+
+	review, err := s.readActiveReview(ctx, reviewID)
+	if err != nil {
+		return err
+	}
+	if err := linkEntity(ctx, review); err != nil {
+		return err
+	}
+	if review.EntityID == "" {
+		return apierr.New(apierr.NotFound, "the website %s of review %s isn't a registered entity", review.Website, reviewID)
+	}
+
+	if err := assertControlsEntity(ctx, review.EntityID); err != nil {
+		return err
+	}
+
+	userID, err := s.userID(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get user identity: %v", err)
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	existing, err := readOfficialResponse(ctx, reviewID)
+	if err != nil {
+		return err
+	}
+
+	updated := &OfficialResponse{
+		ReviewID:    reviewID,
+		EntityID:    review.EntityID,
+		Response:    response,
+		ResponderID: userID,
+		Version:     1,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if existing != nil {
+		updated.Version = existing.Version + 1
+		updated.CreatedAt = existing.CreatedAt
+	}
+
+	key, err := responseKey(ctx, reviewID)
+	if err != nil {
+		return err
+	}
+	updated.DocType, updated.SchemaVersion = responseObjectType, schemaVersion
+	responseJSON, err := json.Marshal(updated)
+	if err != nil {
+		return fmt.Errorf("failed to marshal official response: %v", err)
+	}
+	if err := ctx.GetStub().PutState(key, responseJSON); err != nil {
+		return fmt.Errorf("failed to write official response: %v", err)
+	}
+
+	changed, err := changedFields(existing, updated)
+	if err != nil {
+		return err
+	}
+
+	return emitEvent(ctx, events.OfficialResponsePosted, reviewID, "", userID, changed)
+}
+
+// GetOfficialResponseHistory returns every version of the official response to a review, oldest first.
+// Like in GetReviewHistory, while the review is withheld the versions are stripped of the response
+// and the changes down to the names of the fields, unless the caller is a moderator
+func (s *ReviewContract) GetOfficialResponseHistory(ctx contractapi.TransactionContextInterface, reviewID string) ([]OfficialResponseVersion, error) {
+	_, err := ulid.ParseStrict(reviewID)
+	if err != nil {
+		return nil, apierr.New(apierr.InvalidArgument, "reviewID isn't ULID %v", err)
+	}
+
+	redact, err := historyWithheld(ctx, reviewID)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := responseKey(ctx, reviewID)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := readHistory(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history of the official response to review %s: %v", reviewID, err)
+	}
+
+	history := make([]OfficialResponseVersion, 0, len(entries))
+	for _, entry := range entries {
+		version := OfficialResponseVersion{TxID: entry.txID, Timestamp: entry.timestamp, IsDelete: entry.isDelete, Changes: entry.changes}
+		if !entry.isDelete {
+			var response OfficialResponse
+			if err := decodeDocument(responseObjectType, entry.value, &response); err != nil {
+				return nil, fmt.Errorf("failed to decode official response at tx %s: %v", entry.txID, err)
+			}
+			version.Record = &response
+		}
+		if redact {
+			if version.Record != nil {
+				version.Record = &OfficialResponse{ReviewID: version.Record.ReviewID, EntityID: version.Record.EntityID,
+					ResponderID: version.Record.ResponderID, Version: version.Record.Version}
+			}
+			version.Changes = redactedChanges(version.Changes)
+		}
+		history = append(history, version)
+	}
+
+	return history, nil
+}
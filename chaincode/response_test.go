@@ -0,0 +1,90 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/edgeflare/fabreview/apierr"
+	"github.com/edgeflare/fabreview/events"
+)
+
+// representative of the entity of technobd.com, verified by the CA of the admin MSP
+var representative = identity{mspID: "Org1MSP", cn: "rep", attrs: map[string]string{verifiedDomainsAttribute: "example.org, technobd.com"}}
+
+func TestPostOfficialResponse(t *testing.T) {
+	s := newTestStub(t)
+	s.registerEntity("TechnoBD", "technobd.com")
+	id := s.createReview(alice, "https://technobd.com/")
+
+	s.mustInvoke(representative, "PostOfficialResponse", id, "We've raised salaries since.")
+	if event := s.lastEvent(); event.Name != events.OfficialResponsePosted || event.ReviewID != id {
+		t.Errorf("got event %+v", event)
+	}
+	first := s.readReview(id).OfficialResponse
+	if first == nil || first.Version != 1 || first.Response != "We've raised salaries since." {
+		t.Fatalf("got %+v", first)
+	}
+
+	s.mustInvoke(representative, "PostOfficialResponse", id, "We've raised salaries twice since.")
+	response := s.readReview(id).OfficialResponse
+	if response.Version != 2 || !response.CreatedAt.Equal(first.CreatedAt) || !response.UpdatedAt.After(first.UpdatedAt) {
+		t.Errorf("got %+v after replacing %+v", response, first)
+	}
+	if len(s.readReview(id).Comments) != 0 {
+		t.Error("the response shows as a comment")
+	}
+
+	var history []OfficialResponseVersion
+	s.mustInvokeJSON(&history, reader, "GetOfficialResponseHistory", id)
+	if len(history) != 2 || history[0].Record.Version != 1 || history[1].Record.Version != 2 {
+		t.Errorf("got history %+v", history)
+	}
+}
+
+func TestOfficialResponseHistoryRedacted(t *testing.T) {
+	s := newTestStub(t)
+	s.registerEntity("TechnoBD", "technobd.com")
+	id := s.createReview(alice, "technobd.com")
+	s.mustInvoke(representative, "PostOfficialResponse", id, "We've raised salaries since.")
+	s.mustInvoke(representative, "PostOfficialResponse", id, "The manager named here has left.")
+	s.mustInvoke(alice, "DeleteReview", id)
+
+	var history []OfficialResponseVersion
+	s.mustInvokeJSON(&history, reader, "GetOfficialResponseHistory", id)
+	if len(history) != 2 {
+		t.Fatalf("got %d versions, want 2", len(history))
+	}
+	for _, version := range history {
+		if version.Record.Response != "" || version.Record.ReviewID != id {
+			t.Errorf("version at tx %s isn't redacted: %+v", version.TxID, version.Record)
+		}
+		for _, change := range version.Changes {
+			if change.Old != nil || change.New != nil {
+				t.Errorf("change of %s at tx %s keeps its values", change.Field, version.TxID)
+			}
+		}
+	}
+
+	s.mustInvokeJSON(&history, moderator, "GetOfficialResponseHistory", id)
+	if history[1].Record.Response != "The manager named here has left." {
+		t.Errorf("got %+v for a moderator", history[1].Record)
+	}
+}
+
+func TestPostOfficialResponseUnauthorized(t *testing.T) {
+	s := newTestStub(t)
+	s.registerEntity("TechnoBD", "technobd.com")
+	id := s.createReview(alice, "technobd.com")
+
+	others := []identity{
+		alice,
+		{mspID: "Org1MSP", cn: "rival", attrs: map[string]string{verifiedDomainsAttribute: "example.org"}},
+		// another MSP's CA can't verify domains
+		{mspID: "Org2MSP", cn: "rep", attrs: representative.attrs},
+	}
+	for _, caller := range others {
+		s.wantError(apierr.Forbidden, caller, "PostOfficialResponse", id, "Not ours to answer.")
+	}
+
+	s.wantError(apierr.NotFound, representative, "PostOfficialResponse", s.createReview(alice, "example.com"), "Unregistered.")
+	s.wantError(apierr.InvalidArgument, representative, "PostOfficialResponse", "not-a-ulid", "Invalid.")
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/edgeflare/fabreview/apierr"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Stored documents record their doc_type and the schema_version they were written with. Reads upgrade older
+// documents in memory through the registered migrations, and writes store them upgraded; MigrateBatch upgrades
+// those of each review nobody writes to. Documents stored under a composite key other than reviews, comments
+// and votes use its object type as doc type, eg a vote tally checkpoint is a votetally.
+//
+// Some values aren't documents and have no version: entitydomain~ and idclaim~ keys hold a bare ID,
+// and the pseudonym secret raw bytes.
+const (
+	reviewDocType  = "review"
+	commentDocType = "comment"
+	voteDocType    = "vote"
+	privateDocType = "reviewprivate" // private details, stored under the review ID in a private data collection
+
+	// schemaVersion is the version of the stored data model. Bump it with each change older documents
+	// must be upgraded for, and register the upgrade in migrations
+	schemaVersion = 1
+)
+
+// migration upgrades a document of docType from version from to from+1. It edits the decoded JSON
+// rather than the struct, so it can read fields the struct no longer has
+type migration struct {
+	docType string
+	from    int
+	upgrade func(doc map[string]any) error
+}
+
+// migrations lists the upgrades of each document type. A version without one for a document type
+// only records the new version in its documents
+var migrations = []migration{
+	{reviewDocType, 0, upgradeReviewV0},
+	{commentDocType, 0, upgradeCommentV0},
+}
+
+// MigrationBatch is the result of MigrateBatch
+type MigrationBatch struct {
+	Migrated int    `json:"migrated"` // documents upgraded
+	Bookmark string `json:"bookmark"` // pass back to continue, empty once all reviews have been visited
+}
+
+// upgradeReviewV0 upgrades reviews written before schema versioning: their website is canonicalized
+// when possible, and their creation time taken from their ID when it wasn't recorded
+func upgradeReviewV0(doc map[string]any) error {
+	if website, ok := doc["website"].(string); ok {
+		if domain, err := canonicalWebsite(website); err == nil {
+			doc["website"] = domain
+		}
+	}
+	setCreatedAtFromID(doc)
+	return nil
+}
+
+// upgradeCommentV0 upgrades comments written before schema versioning: their creation time is taken
+// from their ID when it wasn't recorded
+func upgradeCommentV0(doc map[string]any) error {
+	setCreatedAtFromID(doc)
+	return nil
+}
+
+// setCreatedAtFromID sets the created_at of a document without one to the time of its ULID
+func setCreatedAtFromID(doc map[string]any) {
+	if _, ok := doc["created_at"]; ok {
+		return
+	}
+	id, _ := doc["id"].(string)
+	if createdAt := idTime(id); !createdAt.IsZero() {
+		doc["created_at"] = createdAt.Format(time.RFC3339Nano)
+	}
+}
+
+// documentVersion returns the schema_version a stored document was written with, 0 if it has none
+func documentVersion(data []byte) (int, error) {
+	var versioned struct {
+		SchemaVersion int `json:"schema_version"`
+	}
+	if err := json.Unmarshal(data, &versioned); err != nil {
+		return 0, err
+	}
+	return versioned.SchemaVersion, nil
+}
+
+// upgradeDocument upgrades a stored document of docType to the current schema version. It returns
+// data unchanged if it's already current, and refuses documents written by a newer chaincode
+func upgradeDocument(docType string, data []byte) ([]byte, error) {
+	version, err := documentVersion(data)
+	if err != nil {
+		return nil, err
+	}
+	if version == schemaVersion {
+		return data, nil
+	}
+	if version > schemaVersion {
+		return nil, fmt.Errorf("%s has schema version %d, newer than the %d this chaincode reads", docType, version, schemaVersion)
+	}
+
+	// numbers are kept as written, eg ratings and vote values
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var doc map[string]any
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	for ; version < schemaVersion; version++ {
+		for _, m := range migrations {
+			if m.docType == docType && m.from == version {
+				if err := m.upgrade(doc); err != nil {
+					return nil, fmt.Errorf("failed to upgrade %s from schema version %d: %v", docType, version, err)
+				}
+			}
+		}
+		doc["doc_type"] = docType
+		doc["schema_version"] = version + 1
+	}
+
+	return json.Marshal(doc)
+}
+
+// decodeDocument decodes a stored document of docType into v, upgrading it first if it's older
+func decodeDocument(docType string, data []byte, v any) error {
+	upgraded, err := upgradeDocument(docType, data)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(upgraded, v)
+}
+
+// decodeReview decodes a stored review, see decodeDocument
+func decodeReview(data []byte, review *Review) error {
+	return decodeDocument(reviewDocType, data, review)
+}
+
+// decodeComment decodes a stored comment, see decodeDocument
+func decodeComment(data []byte, comment *Comment) error {
+	return decodeDocument(commentDocType, data, comment)
+}
+
+// decodeVote decodes a stored vote, see decodeDocument
+func decodeVote(data []byte, vote *Vote) error {
+	return decodeDocument(voteDocType, data, vote)
+}
+
+// migrateDocument upgrades the document stored under key if it's at fromVersion, and reports whether it did
+func migrateDocument(ctx contractapi.TransactionContextInterface, docType, key string, data []byte, fromVersion int) (bool, error) {
+	version, err := documentVersion(data)
+	if err != nil {
+		return false, fmt.Errorf("failed to read schema version of %s: %v", key, err)
+	}
+	if version != fromVersion {
+		return false, nil
+	}
+
+	upgraded, err := upgradeDocument(docType, data)
+	if err != nil {
+		return false, err
+	}
+	if err := ctx.GetStub().PutState(key, upgraded); err != nil {
+		return false, fmt.Errorf("failed to write %s: %v", key, err)
+	}
+	return true, nil
+}
+
+// migratePartialCompositeKey upgrades the documents at fromVersion stored under objectType~attributes
+func migratePartialCompositeKey(ctx contractapi.TransactionContextInterface, docType string, fromVersion int, objectType string, attributes ...string) (int, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(objectType, attributes)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if closeErr := resultsIterator.Close(); closeErr != nil {
+			err = closeErr
+		}
+	}()
+
+	migrated := 0
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return 0, err
+		}
+		upgraded, err := migrateDocument(ctx, docType, queryResponse.Key, queryResponse.Value, fromVersion)
+		if err != nil {
+			return 0, err
+		}
+		if upgraded {
+			migrated++
+		}
+	}
+
+	return migrated, nil
+}
+
+// MigrateBatch upgrades the stored documents at schema version fromVersion, 0 being documents written before
+// versioning, to the current version. It visits at most limit reviews starting at bookmark, each with the
+// documents kept under its ID, and returns the bookmark to continue from, empty once all reviews have been visited.
+// Documents at other versions are left as they are, so a batch can be retried.
+//
+// Entities, statistics, counts and the config aren't kept under a review; like private details, which can't
+// be rewritten without changing the hash recorded in their review, they're upgraded when read and stored
+// upgraded when next written
+func (s *ReviewContract) MigrateBatch(ctx contractapi.TransactionContextInterface, fromVersion int, bookmark string, limit int) (*MigrationBatch, error) {
+	if fromVersion < 0 || fromVersion >= schemaVersion {
+		return nil, apierr.New(apierr.InvalidArgument, "fromVersion must be below the current schema version %d", schemaVersion)
+	}
+	if limit < 1 || limit > maxMigrationBatch {
+		return nil, apierr.New(apierr.InvalidArgument, "limit must be between 1 and %d", maxMigrationBatch)
+	}
+
+	// paginated queries aren't allowed in update transactions, so the batch is bounded by hand
+	batch := &MigrationBatch{}
+	next, err := scanReviews(ctx, bookmark, limit, func(id, key string, value []byte) error {
+		upgraded, err := migrateDocument(ctx, reviewDocType, key, value, fromVersion)
+		if err != nil {
+			return err
+		}
+		if upgraded {
+			batch.Migrated++
+		}
+
+		for _, source := range []struct{ docType, objectType string }{
+			{commentDocType, commentObjectType},
+			{voteDocType, voteObjectType},
+			{voteDocType, commentVoteObjectType},
+			{voteDeltaObjectType, voteDeltaObjectType},
+			{voteTallyObjectType, voteTallyObjectType},
+			{flagObjectType, flagObjectType},
+			{flagQueueObjectType, flagQueueObjectType},
+			{moderationLogObjectType, moderationLogObjectType},
+			{evidenceObjectType, evidenceObjectType},
+			{responseObjectType, responseObjectType},
+		} {
+			migrated, err := migratePartialCompositeKey(ctx, source.docType, fromVersion, source.objectType, id)
+			if err != nil {
+				return err
+			}
+			batch.Migrated += migrated
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	batch.Bookmark = next
+
+	return batch, nil
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// storedVersion decodes the doc_type and schema_version of a stored document
+func storedVersion(t *testing.T, data []byte) (string, int) {
+	t.Helper()
+
+	var versioned struct {
+		DocType       string `json:"doc_type"`
+		SchemaVersion int    `json:"schema_version"`
+	}
+	if err := json.Unmarshal(data, &versioned); err != nil {
+		t.Fatal(err)
+	}
+	return versioned.DocType, versioned.SchemaVersion
+}
+
+func TestUpgradeDocument(t *testing.T) {
+	id := "01M56TZWV7X54WAT790FNYC6F1"
+	upgraded, err := upgradeDocument(reviewDocType, []byte(`{"id":"`+id+`","website":"https://www.Example.com/jobs","rating":7}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var review Review
+	if err := json.Unmarshal(upgraded, &review); err != nil {
+		t.Fatal(err)
+	}
+	if review.DocType != reviewDocType || review.SchemaVersion != schemaVersion || review.Website != "example.com" ||
+		review.Rating != 7 || !review.CreatedAt.Equal(idTime(id)) {
+		t.Errorf("got %+v", review)
+	}
+
+	current := []byte(`{"count":2,"doc_type":"count","schema_version":1}`)
+	if got, err := upgradeDocument(countObjectType, current); err != nil || string(got) != string(current) {
+		t.Errorf("got %s, %v for a current document", got, err)
+	}
+	if _, err := upgradeDocument(countObjectType, []byte(`{"count":2,"schema_version":2}`)); err == nil {
+		t.Error("upgraded a document of a newer schema version")
+	}
+}
+
+func TestDocumentsAreVersioned(t *testing.T) {
+	s := newTestStub(t)
+	s.mustInvoke(admin, "SetConfig", toJSON(t, defaultConfig()))
+	s.registerEntity("TechnoBD", "technobd.com")
+
+	input := s.reviewInput("technobd.com")
+	private := ReviewPrivate{Email: "hr@technobd.com", Salt: "0123456789abcdef"}
+	s.mustInvokeTransient(alice, map[string][]byte{transientPrivateKey: []byte(toJSON(t, private))}, "CreateReviewV2", toJSON(t, input))
+	commentID := s.newID()
+	s.mustInvoke(bob, "AddComment", input.ID, commentID, "Agreed.")
+	s.mustInvoke(alice, "Vote", input.ID, "1", commentID)
+	s.mustInvoke(alice, "FlagComment", input.ID, commentID, string(FlagSpam))
+	sum := sha256.Sum256([]byte("offer letter"))
+	s.mustInvoke(alice, "AnchorEvidence", input.ID, hex.EncodeToString(sum[:]), "application/pdf", "2048")
+	s.mustInvoke(representative, "PostOfficialResponse", input.ID, "We've raised salaries since.")
+	s.mustInvoke(admin, "CompactVotes", input.ID, "")
+	s.mustInvoke(admin, "RebuildStats", "technobd.com")
+	s.mustInvoke(admin, "RecountAll")
+	s.flagUntilHidden(s.createReview(alice, "example.com"))
+
+	docTypes := map[string]bool{}
+	for key, value := range s.State {
+		// bare keys hold reviews written before composite keys
+		objectType := reviewObjectType
+		if strings.HasPrefix(key, "\x00") {
+			var err error
+			if objectType, _, err = s.SplitCompositeKey(key); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// bare IDs, not documents
+		if objectType == entityDomainObjectType || objectType == identityClaimObjectType {
+			continue
+		}
+
+		docType, version := storedVersion(t, value)
+		if docType == "" || version != schemaVersion {
+			t.Errorf("%q is stored as %q version %d", key, docType, version)
+		}
+		docTypes[docType] = true
+	}
+	for _, docType := range []string{
+		reviewDocType, commentDocType, voteDocType, configObjectType, entityObjectType, flagObjectType, flagQueueObjectType,
+		moderationLogObjectType, evidenceObjectType, responseObjectType, voteTallyObjectType, voteDeltaObjectType,
+		statsObjectType, statsDeltaObjectType, countObjectType, countDeltaObjectType,
+	} {
+		if !docTypes[docType] {
+			t.Errorf("no %s stored", docType)
+		}
+	}
+
+	if docType, version := storedVersion(t, s.PvtState["Org1MSPPrivateCollection"][input.ID]); docType != privateDocType || version != schemaVersion {
+		t.Errorf("private details stored as %q version %d", docType, version)
+	}
+}
+
+func TestMigrateBatch(t *testing.T) {
+	s := newTestStub(t)
+	id := s.newID()
+	s.seed(Review{ID: id, Title: "Legacy", Website: "https://www.Example.com/", Summary: "Written before versioning.", Rating: 4, Country: "BD", UserID: "anon-author"}, reviewObjectType, id)
+	s.seed(Flag{UserID: "anon-flagger", Reason: FlagSpam, FlaggedAt: s.now}, flagObjectType, id, id, "anon-flagger")
+	s.seed(FlaggedItem{ReviewID: id}, flagQueueObjectType, id, id)
+	s.seed(ModerationAction{TxID: "tx", ReviewID: id, Action: ModerationAutoHide, At: s.now}, moderationLogObjectType, id, "tx")
+	s.seed(VoteTally{ReviewID: id, Upvotes: 3, Score: 3}, voteTallyObjectType, id, id)
+	entityID := s.newID()
+	s.seed(Entity{ID: entityID, Domain: "example.com", Name: "Example", Aliases: []string{}, CreatedAt: s.now}, entityObjectType, entityID)
+
+	var batch MigrationBatch
+	s.mustInvokeJSON(&batch, admin, "MigrateBatch", "0", "", "10")
+	if batch.Migrated != 5 || batch.Bookmark != "" {
+		t.Errorf("got %+v, want the review and its 4 documents migrated", batch)
+	}
+	for _, objectType := range []string{reviewObjectType, flagObjectType, flagQueueObjectType, moderationLogObjectType, voteTallyObjectType} {
+		iterator, err := s.GetStateByPartialCompositeKey(objectType, []string{id})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for iterator.HasNext() {
+			kv, err := iterator.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if docType, version := storedVersion(t, kv.Value); docType != objectType || version != schemaVersion {
+				t.Errorf("%q is stored as %q version %d", kv.Key, docType, version)
+			}
+		}
+		iterator.Close()
+	}
+	if review := s.readReview(id); review.Website != "example.com" {
+		t.Errorf("got website %q, want it canonicalized", review.Website)
+	}
+
+	// entities aren't kept under a review, they're upgraded when read
+	var entities PaginatedEntities
+	s.mustInvokeJSON(&entities, reader, "ListEntities", "10", "")
+	if len(entities.Records) != 1 || entities.Records[0].SchemaVersion != schemaVersion {
+		t.Errorf("got %+v", entities.Records)
+	}
+	if tally := s.voteTally(id, ""); tally.Upvotes != 3 {
+		t.Errorf("got %+v from the migrated checkpoint", tally)
+	}
+
+	batch = MigrationBatch{}
+	s.mustInvokeJSON(&batch, admin, "MigrateBatch", "0", "", "10")
+	if batch.Migrated != 0 {
+		t.Errorf("migrated %d documents twice", batch.Migrated)
+	}
+}
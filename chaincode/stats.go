@@ -0,0 +1,311 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/edgeflare/fabreview/apierr"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Rating statistics are kept per website like vote tallies: every transaction changing a review writes
+// a delta per review under its own key, so reviews of the same website don't conflict, and RebuildStats replaces
+// the deltas with a checkpoint recomputed from the reviews. Reviews count unless deleted or hidden.
+const (
+	statsDeltaObjectType = "statsdelta" // statsdelta~website~txID~reviewID
+	statsObjectType      = "stats"      // stats~website
+	topTags              = 5            // number of positives and negatives GetEntityStats returns
+)
+
+// EntityStats holds the aggregate ratings of the reviews of a website
+type EntityStats struct {
+	Website      string     `json:"website"`
+	Count        int        `json:"count"`
+	Sum          int        `json:"sum"`
+	Average      float64    `json:"average"`   // rounded to 2 decimals, 0 without reviews
+	Histogram    []int      `json:"histogram"` // number of reviews rated 1 to 10
+	TopPositives []TagCount `json:"top_positives"`
+	TopNegatives []TagCount `json:"top_negatives"`
+}
+
+// TagCount is the number of reviews listing a positive or negative
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
+}
+
+// statsDelta is the change a single transaction made to the statistics of a website, or their checkpoint
+type statsDelta struct {
+	Count     int            `json:"count"`
+	Sum       int            `json:"sum"`
+	Histogram []int          `json:"histogram"`
+	Positives map[string]int `json:"positives,omitzero"`
+	Negatives map[string]int `json:"negatives,omitzero"`
+	// DocType and SchemaVersion identify the stored document, see schemaVersion
+	DocType       string `json:"doc_type,omitzero"`
+	SchemaVersion int    `json:"schema_version,omitzero"`
+}
+
+// newStatsDelta returns an empty delta
+func newStatsDelta() *statsDelta {
+	return &statsDelta{Histogram: make([]int, 10), Positives: map[string]int{}, Negatives: map[string]int{}}
+}
+
+// counted reports whether a review counts towards the statistics of its website
+func counted(review *Review) bool {
+	return review != nil && review.Tombstone == nil && review.Hidden == nil
+}
+
+// add counts n times review into the delta
+func (d *statsDelta) add(review *Review, n int) {
+	d.Count += n
+	d.Sum += int(review.Rating) * n
+	if review.Rating >= 1 && review.Rating <= 10 {
+		d.Histogram[review.Rating-1] += n
+	}
+	addTags(d.Positives, review.Positives, n)
+	addTags(d.Negatives, review.Negatives, n)
+}
+
+// merge adds other into the delta
+func (d *statsDelta) merge(other *statsDelta) {
+	d.Count += other.Count
+	d.Sum += other.Sum
+	for i, n := range other.Histogram {
+		d.Histogram[i] += n
+	}
+	for tag, n := range other.Positives {
+		addTags(d.Positives, []string{tag}, n)
+	}
+	for tag, n := range other.Negatives {
+		addTags(d.Negatives, []string{tag}, n)
+	}
+}
+
+// empty reports whether the delta changes nothing
+func (d *statsDelta) empty() bool {
+	return d.Count == 0 && d.Sum == 0 && !slices.ContainsFunc(d.Histogram, func(n int) bool { return n != 0 }) &&
+		len(d.Positives) == 0 && len(d.Negatives) == 0
+}
+
+// addTags counts n times tags into counts, case-insensitively. Tags counted zero times are removed
+func addTags(counts map[string]int, tags []string, n int) {
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		counts[tag] += n
+		if counts[tag] == 0 {
+			delete(counts, tag)
+		}
+	}
+}
+
+// statsWebsite returns the website the statistics of a review are kept under. Reviews written before
+// websites were canonicalized count towards their canonical domain
+func statsWebsite(website string) string {
+	if domain, err := canonicalWebsite(website); err == nil {
+		return domain
+	}
+	return website
+}
+
+// recordStats writes the deltas turning the statistics of before into those of after, two versions of a review.
+// Either may be nil, and they may belong to different websites. The deltas are keyed by review as well as
+// transaction, so a transaction changing several reviews of a website writes one for each
+func recordStats(ctx contractapi.TransactionContextInterface, before, after *Review) error {
+	reviewID := ""
+	for _, review := range []*Review{before, after} {
+		if review != nil {
+			reviewID = review.ID
+		}
+	}
+
+	deltas := map[string]*statsDelta{}
+	count := func(review *Review, n int) {
+		if !counted(review) {
+			return
+		}
+		website := statsWebsite(review.Website)
+		if deltas[website] == nil {
+			deltas[website] = newStatsDelta()
+		}
+		deltas[website].add(review, n)
+	}
+	count(before, -1)
+	count(after, 1)
+
+	// sorted, so every endorser writes the keys in the same order
+	websites := make([]string, 0, len(deltas))
+	for website := range deltas {
+		websites = append(websites, website)
+	}
+	slices.Sort(websites)
+
+	for _, website := range websites {
+		delta := deltas[website]
+		if delta.empty() {
+			continue
+		}
+
+		key, err := ctx.GetStub().CreateCompositeKey(statsDeltaObjectType, []string{website, ctx.GetStub().GetTxID(), reviewID})
+		if err != nil {
+			return err
+		}
+		delta.DocType, delta.SchemaVersion = statsDeltaObjectType, schemaVersion
+		deltaJSON, err := json.Marshal(delta)
+		if err != nil {
+			return fmt.Errorf("failed to marshal stats delta: %v", err)
+		}
+		if err := ctx.GetStub().PutState(key, deltaJSON); err != nil {
+			return fmt.Errorf("failed to write stats delta: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// readStats sums the checkpoint and the deltas of a website written since. It also returns the delta keys it read
+func readStats(ctx contractapi.TransactionContextInterface, website string) (*statsDelta, []string, error) {
+	stats := newStatsDelta()
+
+	checkpointKey, err := ctx.GetStub().CreateCompositeKey(statsObjectType, []string{website})
+	if err != nil {
+		return nil, nil, err
+	}
+	checkpointJSON, err := ctx.GetStub().GetState(checkpointKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if checkpointJSON != nil {
+		checkpoint := newStatsDelta()
+		if err := decodeDocument(statsObjectType, checkpointJSON, checkpoint); err != nil {
+			return nil, nil, err
+		}
+		stats.merge(checkpoint)
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(statsDeltaObjectType, []string{website})
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		if closeErr := resultsIterator.Close(); closeErr != nil {
+			err = closeErr
+		}
+	}()
+
+	var deltaKeys []string
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		delta := newStatsDelta()
+		if err := decodeDocument(statsDeltaObjectType, queryResponse.Value, delta); err != nil {
+			return nil, nil, err
+		}
+		stats.merge(delta)
+		deltaKeys = append(deltaKeys, queryResponse.Key)
+	}
+
+	return stats, deltaKeys, nil
+}
+
+// entityStats presents the statistics of a website
+func entityStats(website string, stats *statsDelta) *EntityStats {
+	result := &EntityStats{
+		Website:      website,
+		Count:        stats.Count,
+		Sum:          stats.Sum,
+		Histogram:    stats.Histogram,
+		TopPositives: topTagCounts(stats.Positives),
+		TopNegatives: topTagCounts(stats.Negatives),
+	}
+	if stats.Count > 0 {
+		result.Average = math.Round(float64(stats.Sum)/float64(stats.Count)*100) / 100
+	}
+	return result
+}
+
+// topTagCounts returns the most listed tags, ties broken alphabetically
+func topTagCounts(counts map[string]int) []TagCount {
+	tags := make([]TagCount, 0, len(counts))
+	for tag, n := range counts {
+		tags = append(tags, TagCount{Tag: tag, Count: n})
+	}
+	slices.SortFunc(tags, func(a, b TagCount) int {
+		return cmp.Or(cmp.Compare(b.Count, a.Count), cmp.Compare(a.Tag, b.Tag))
+	})
+	return tags[:min(len(tags), topTags)]
+}
+
+// GetEntityStats returns the number of reviews of a website, their average rating,
+// the distribution of ratings and the positives and negatives most listed
+func (s *ReviewContract) GetEntityStats(ctx contractapi.TransactionContextInterface, website string) (*EntityStats, error) {
+	website, err := canonicalWebsite(website)
+	if err != nil {
+		return nil, apierr.New(apierr.InvalidArgument, "invalid website: %v", err)
+	}
+
+	stats, _, err := readStats(ctx, website)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stats: %v", err)
+	}
+
+	return entityStats(website, stats), nil
+}
+
+// RebuildStats recomputes the statistics of a website from its reviews and replaces the stored checkpoint
+// and deltas with them. Reviews still stored under their bare ID count too. It reads every review, so it conflicts
+// with reviews created meanwhile and must be retried. Only admins may rebuild statistics.
+func (s *ReviewContract) RebuildStats(ctx contractapi.TransactionContextInterface, website string) (*EntityStats, error) {
+	website, err := canonicalWebsite(website)
+	if err != nil {
+		return nil, apierr.New(apierr.InvalidArgument, "invalid website: %v", err)
+	}
+
+	_, deltaKeys, err := readStats(ctx, website)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stats: %v", err)
+	}
+
+	stats := newStatsDelta()
+	err = forEachReview(ctx, func(id, key string, value []byte) error {
+		var review Review
+		if err := decodeReview(value, &review); err != nil {
+			return err
+		}
+		if statsWebsite(review.Website) == website && counted(&review) {
+			stats.add(&review, 1)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	checkpointKey, err := ctx.GetStub().CreateCompositeKey(statsObjectType, []string{website})
+	if err != nil {
+		return nil, err
+	}
+	stats.DocType, stats.SchemaVersion = statsObjectType, schemaVersion
+	statsJSON, err := json.Marshal(stats)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal stats: %v", err)
+	}
+	if err := ctx.GetStub().PutState(checkpointKey, statsJSON); err != nil {
+		return nil, fmt.Errorf("failed to write stats: %v", err)
+	}
+
+	for _, key := range deltaKeys {
+		if err := ctx.GetStub().DelState(key); err != nil {
+			return nil, fmt.Errorf("failed to delete stats delta: %v", err)
+		}
+	}
+
+	return entityStats(website, stats), nil
+}
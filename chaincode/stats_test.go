@@ -0,0 +1,86 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/edgeflare/fabreview/apierr"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// entityStats returns the statistics of website, as GetEntityStats does
+func (s *testStub) entityStats(website string) EntityStats {
+	s.t.Helper()
+
+	var stats EntityStats
+	s.mustInvokeJSON(&stats, reader, "GetEntityStats", website)
+	return stats
+}
+
+func TestEntityStats(t *testing.T) {
+	s := newTestStub(t)
+	first := s.reviewInput("example.com")
+	first.Rating, first.Positives = 8, []string{"Pay", "team"}
+	second := s.reviewInput("https://www.example.com/jobs")
+	second.Rating, second.Positives, second.Negatives = 5, []string{"pay "}, []string{"hours"}
+	for _, input := range []ReviewInput{first, second} {
+		s.mustInvoke(alice, "CreateReviewV2", toJSON(t, input))
+	}
+	s.createReview(alice, "example.org")
+
+	stats := s.entityStats("EXAMPLE.com")
+	if stats.Website != "example.com" || stats.Count != 2 || stats.Sum != 13 || stats.Average != 6.5 || stats.Histogram[7] != 1 || stats.Histogram[4] != 1 {
+		t.Errorf("got %+v", stats)
+	}
+	if want := []TagCount{{"pay", 2}, {"team", 1}}; !slices.Equal(stats.TopPositives, want) {
+		t.Errorf("got positives %v, want %v", stats.TopPositives, want)
+	}
+
+	// deleted and edited reviews leave the statistics
+	s.mustInvoke(alice, "DeleteReview", second.ID)
+	s.mustInvoke(alice, "PatchReview", toJSON(t, ReviewPatch{ID: first.ID, Rating: 10}))
+	if stats := s.entityStats("example.com"); stats.Count != 1 || stats.Sum != 10 || len(stats.TopNegatives) != 0 {
+		t.Errorf("got %+v", stats)
+	}
+
+	s.wantError(apierr.InvalidArgument, reader, "GetEntityStats", "not a website")
+}
+
+func TestStatsOfReviewsInOneTransaction(t *testing.T) {
+	s := newTestStub(t)
+	first := &Review{ID: s.newID(), Website: "example.com", Rating: 4}
+	second := &Review{ID: s.newID(), Website: "example.com", Rating: 6}
+
+	s.inTransaction(admin, func(ctx contractapi.TransactionContextInterface) error {
+		if err := recordStats(ctx, nil, first); err != nil {
+			return err
+		}
+		return recordStats(ctx, nil, second)
+	})
+
+	if stats := s.entityStats("example.com"); stats.Count != 2 || stats.Sum != 10 {
+		t.Errorf("got %+v, want both reviews counted", stats)
+	}
+}
+
+func TestRebuildStats(t *testing.T) {
+	s := newTestStub(t)
+	s.createReview(alice, "example.com")
+	s.createReview(bob, "example.com")
+	want := s.entityStats("example.com")
+
+	s.wantError(apierr.Forbidden, moderator, "RebuildStats", "example.com")
+	var rebuilt EntityStats
+	s.mustInvokeJSON(&rebuilt, admin, "RebuildStats", "example.com")
+	if rebuilt.Count != want.Count || rebuilt.Sum != want.Sum {
+		t.Errorf("rebuilt %+v, want %+v", rebuilt, want)
+	}
+	if n := s.countKeys(statsDeltaObjectType, "example.com"); n != 0 {
+		t.Errorf("%d deltas left after rebuilding", n)
+	}
+
+	s.createReview(carol, "example.com")
+	if stats := s.entityStats("example.com"); stats.Count != 3 {
+		t.Errorf("got %d reviews after the checkpoint, want 3", stats.Count)
+	}
+}
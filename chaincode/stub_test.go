@@ -0,0 +1,542 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"maps"
+	"math/big"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/edgeflare/fabreview/apierr"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	"github.com/hyperledger/fabric-protos-go/msp"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/oklog/ulid/v2"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/protoadapt"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// testSecret is the pseudonym secret newTestStub sets
+const testSecret = "fabreview-test-pseudonym-secret-0123456789"
+
+// identity is a transaction submitter: the MSP and CommonName of their certificate, and its attributes
+type identity struct {
+	mspID string
+	cn    string
+	attrs map[string]string
+}
+
+var (
+	admin     = identity{mspID: "Org1MSP", cn: "admin", attrs: map[string]string{"hf.Type": "admin"}}
+	moderator = identity{mspID: "Org1MSP", cn: "moderator", attrs: map[string]string{roleAttribute: string(RoleModerator)}}
+	reader    = identity{mspID: "Org1MSP", cn: "reader", attrs: map[string]string{roleAttribute: string(RoleReadOnly)}}
+	alice     = identity{mspID: "Org1MSP", cn: "alice"}
+	bob       = identity{mspID: "Org1MSP", cn: "bob"}
+	carol     = identity{mspID: "Org2MSP", cn: "carol"}
+)
+
+// attrOID is the certificate extension Fabric CA stores attributes in
+var attrOID = asn1.ObjectIdentifier{1, 2, 3, 4, 5, 6, 7, 8, 1}
+
+// serialize returns the identity as GetCreator does, with a self-signed certificate
+func (id identity) serialize(t *testing.T) []byte {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: id.cn, OrganizationalUnit: []string{"client"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	if id.attrs != nil {
+		attrsJSON, err := json.Marshal(map[string]any{"attrs": id.attrs})
+		if err != nil {
+			t.Fatal(err)
+		}
+		template.ExtraExtensions = []pkix.Extension{{Id: attrOID, Value: attrsJSON}}
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// fabric-protos-go messages implement the v1 proto API
+	creator, err := proto.Marshal(protoadapt.MessageV2Of(&msp.SerializedIdentity{
+		Mspid:   id.mspID,
+		IdBytes: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return creator
+}
+
+// testStub completes shimtest.MockStub with what the contract relies on and the mock lacks: range queries
+// that skip composite keys like Fabric's, paginated queries, key history and events. Like Fabric, it buffers
+// the writes of a transaction until it succeeds, so reads within the transaction don't see them, and
+// discards those of failed transactions. Rich queries aren't evaluated: they return every review,
+// and are recorded in queries for tests to check
+type testStub struct {
+	*shimtest.MockStub
+	t  *testing.T
+	cc *contractapi.ContractChaincode
+
+	now     time.Time // time of the last transaction
+	txCount int
+	lastTx  string // ID of the last transaction
+	args    [][]byte
+
+	history map[string][]*queryresult.KeyModification // oldest first
+	pending map[string]*queryresult.KeyModification   // written by the current transaction, committed when it succeeds
+	// pendingPrivate holds the private data written by the current transaction by collection, nil when deleted
+	pendingPrivate map[string]map[string][]byte
+	queries        []string
+	event          *pb.ChaincodeEvent // set by the last transaction
+}
+
+// newTestStub returns a ledger with the pseudonym secret set. Org1MSP is the admin MSP
+func newTestStub(t *testing.T) *testStub {
+	t.Helper()
+
+	t.Setenv(adminMSPsEnv, "Org1MSP")
+	cc, err := contractapi.NewChaincode(&ReviewContract{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &testStub{
+		MockStub: shimtest.NewMockStub("fabreview", nil),
+		t:        t,
+		cc:       cc,
+		now:      time.Now().UTC().Truncate(time.Millisecond),
+		history:  map[string][]*queryresult.KeyModification{},
+	}
+
+	s.mustInvokeTransient(admin, map[string][]byte{transientSecretKey: []byte(testSecret)}, "SetPseudonymSecret")
+	return s
+}
+
+// advance moves the clock of the next transactions forward
+func (s *testStub) advance(d time.Duration) {
+	s.now = s.now.Add(d)
+}
+
+// newID returns a ULID of the next transaction's time
+func (s *testStub) newID() string {
+	return ulid.MustNew(ulid.Timestamp(s.now.Add(time.Second)), rand.Reader).String()
+}
+
+// invokeTransient submits a transaction with a transient map, and returns its response
+func (s *testStub) invokeTransient(caller identity, transient map[string][]byte, function string, args ...string) pb.Response {
+	s.t.Helper()
+
+	s.txCount++
+	s.now = s.now.Add(time.Second)
+	txID := fmt.Sprintf("%064x", s.txCount)
+	s.lastTx = txID
+	s.MockTransactionStart(txID)
+	defer s.MockTransactionEnd(txID)
+	s.TxTimestamp = timestamppb.New(s.now)
+	s.Creator = caller.serialize(s.t)
+	s.TransientMap = transient
+	s.event = nil
+	s.pending, s.pendingPrivate = map[string]*queryresult.KeyModification{}, map[string]map[string][]byte{}
+
+	s.args = [][]byte{[]byte(function)}
+	for _, arg := range args {
+		s.args = append(s.args, []byte(arg))
+	}
+
+	response := s.cc.Invoke(s)
+	if response.Status != shim.OK {
+		s.event = nil
+		return response
+	}
+
+	s.commit()
+	return response
+}
+
+// commit applies the writes of the current transaction
+func (s *testStub) commit() {
+	s.t.Helper()
+
+	for _, key := range slices.Sorted(maps.Keys(s.pending)) {
+		modification := s.pending[key]
+		write := s.MockStub.DelState
+		if !modification.IsDelete {
+			write = func(key string) error { return s.MockStub.PutState(key, modification.Value) }
+		}
+		if err := write(key); err != nil {
+			s.t.Fatal(err)
+		}
+		s.history[key] = append(s.history[key], modification)
+	}
+
+	for collection, values := range s.pendingPrivate {
+		for key, value := range values {
+			if value == nil {
+				delete(s.PvtState[collection], key)
+				continue
+			}
+			if err := s.MockStub.PutPrivateData(collection, key, value); err != nil {
+				s.t.Fatal(err)
+			}
+		}
+	}
+}
+
+// inTransaction runs f, which must succeed, as a single transaction of caller. It tests what no
+// transaction of the contract does on its own
+func (s *testStub) inTransaction(caller identity, f func(ctx contractapi.TransactionContextInterface) error) {
+	s.t.Helper()
+
+	s.txCount++
+	s.now = s.now.Add(time.Second)
+	txID := fmt.Sprintf("%064x", s.txCount)
+	s.lastTx = txID
+	s.MockTransactionStart(txID)
+	defer s.MockTransactionEnd(txID)
+	s.TxTimestamp = timestamppb.New(s.now)
+	s.Creator = caller.serialize(s.t)
+	s.pending, s.pendingPrivate = map[string]*queryresult.KeyModification{}, map[string]map[string][]byte{}
+
+	ctx := &contractapi.TransactionContext{}
+	ctx.SetStub(s)
+	if err := f(ctx); err != nil {
+		s.t.Fatal(err)
+	}
+	s.commit()
+}
+
+// invoke submits a transaction, and returns its response
+func (s *testStub) invoke(caller identity, function string, args ...string) pb.Response {
+	s.t.Helper()
+	return s.invokeTransient(caller, nil, function, args...)
+}
+
+// mustInvokeTransient submits a transaction with a transient map that must succeed, and returns its payload
+func (s *testStub) mustInvokeTransient(caller identity, transient map[string][]byte, function string, args ...string) []byte {
+	s.t.Helper()
+
+	response := s.invokeTransient(caller, transient, function, args...)
+	if response.Status != shim.OK {
+		s.t.Fatalf("%s(%s): %s", function, strings.Join(args, ", "), response.Message)
+	}
+	return response.Payload
+}
+
+// mustInvoke submits a transaction that must succeed, and returns its payload
+func (s *testStub) mustInvoke(caller identity, function string, args ...string) []byte {
+	s.t.Helper()
+	return s.mustInvokeTransient(caller, nil, function, args...)
+}
+
+// mustInvokeJSON submits a transaction that must succeed, and decodes its payload into result
+func (s *testStub) mustInvokeJSON(result any, caller identity, function string, args ...string) {
+	s.t.Helper()
+
+	payload := s.mustInvoke(caller, function, args...)
+	if err := json.Unmarshal(payload, result); err != nil {
+		s.t.Fatalf("%s returned %s: %v", function, payload, err)
+	}
+}
+
+// invokeError submits a transaction that must fail, and returns its error
+func (s *testStub) invokeError(caller identity, function string, args ...string) *apierr.Error {
+	s.t.Helper()
+
+	response := s.invoke(caller, function, args...)
+	if response.Status == shim.OK {
+		s.t.Fatalf("%s(%s) succeeded, want an error", function, strings.Join(args, ", "))
+	}
+	return apierr.Parse(response.Message)
+}
+
+// wantError fails the test unless the transaction fails with code
+func (s *testStub) wantError(code apierr.Code, caller identity, function string, args ...string) {
+	s.t.Helper()
+
+	if err := s.invokeError(caller, function, args...); err.Code != code {
+		s.t.Fatalf("%s(%s) failed with %s %q, want %s", function, strings.Join(args, ", "), err.Code, err.Message, code)
+	}
+}
+
+// createReview has author create a review of website, and returns its ID
+func (s *testStub) createReview(author identity, website string) string {
+	s.t.Helper()
+
+	id := s.newID()
+	s.mustInvoke(author, "CreateReviewV2", toJSON(s.t, ReviewInput{
+		ID:      id,
+		Title:   "Review of " + website,
+		Website: website,
+		Summary: "A summary long enough to pass validation.",
+		Rating:  7,
+		Country: "BD",
+	}))
+	return id
+}
+
+// readReview returns the review as ReadReview does
+func (s *testStub) readReview(id string) *Review {
+	s.t.Helper()
+
+	var review Review
+	s.mustInvokeJSON(&review, reader, "ReadReview", id)
+	return &review
+}
+
+// seed stores a document as earlier versions of the contract did, outside any transaction of the contract.
+// A key of objectType and attributes is built when attributes are given, otherwise objectType is the key
+func (s *testStub) seed(value any, objectType string, attributes ...string) string {
+	s.t.Helper()
+
+	key := objectType
+	if len(attributes) > 0 {
+		var err error
+		if key, err = shim.CreateCompositeKey(objectType, attributes); err != nil {
+			s.t.Fatal(err)
+		}
+	}
+	valueJSON, ok := value.([]byte)
+	if !ok {
+		valueJSON = []byte(toJSON(s.t, value))
+	}
+
+	s.txCount++
+	txID := fmt.Sprintf("%064x", s.txCount)
+	s.MockTransactionStart(txID)
+	defer s.MockTransactionEnd(txID)
+	if err := s.MockStub.PutState(key, valueJSON); err != nil {
+		s.t.Fatal(err)
+	}
+	s.history[key] = append(s.history[key], &queryresult.KeyModification{TxId: txID, Value: valueJSON, Timestamp: timestamppb.New(s.now)})
+	return key
+}
+
+// countKeys returns the number of stored keys of objectType and attributes
+func (s *testStub) countKeys(objectType string, attributes ...string) int {
+	s.t.Helper()
+
+	iterator, err := s.GetStateByPartialCompositeKey(objectType, attributes)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	defer iterator.Close()
+
+	n := 0
+	for iterator.HasNext() {
+		if _, err := iterator.Next(); err != nil {
+			s.t.Fatal(err)
+		}
+		n++
+	}
+	return n
+}
+
+// toJSON encodes a transaction argument
+func toJSON(t *testing.T, v any) string {
+	t.Helper()
+
+	valueJSON, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(valueJSON)
+}
+
+// itoa formats a numeric transaction argument
+func itoa(n int) string {
+	return strconv.Itoa(n)
+}
+
+func (s *testStub) GetArgs() [][]byte {
+	return s.args
+}
+
+func (s *testStub) GetStringArgs() []string {
+	args := make([]string, 0, len(s.args))
+	for _, arg := range s.args {
+		args = append(args, string(arg))
+	}
+	return args
+}
+
+func (s *testStub) GetFunctionAndParameters() (string, []string) {
+	args := s.GetStringArgs()
+	return args[0], args[1:]
+}
+
+func (s *testStub) PutState(key string, value []byte) error {
+	if key == "" {
+		return fmt.Errorf("empty key")
+	}
+	if len(value) == 0 {
+		return s.DelState(key)
+	}
+	s.pending[key] = &queryresult.KeyModification{TxId: s.TxID, Value: value, Timestamp: s.TxTimestamp}
+	return nil
+}
+
+func (s *testStub) DelState(key string) error {
+	s.pending[key] = &queryresult.KeyModification{TxId: s.TxID, Timestamp: s.TxTimestamp, IsDelete: true}
+	return nil
+}
+
+func (s *testStub) PutPrivateData(collection, key string, value []byte) error {
+	if s.pendingPrivate[collection] == nil {
+		s.pendingPrivate[collection] = map[string][]byte{}
+	}
+	s.pendingPrivate[collection][key] = value
+	return nil
+}
+
+func (s *testStub) DelPrivateData(collection, key string) error {
+	return s.PutPrivateData(collection, key, nil)
+}
+
+// PurgePrivateData deletes the private data, the mock keeps no history of it to purge
+func (s *testStub) PurgePrivateData(collection, key string) error {
+	return s.DelPrivateData(collection, key)
+}
+
+// GetStateByRange leaves out composite keys, as Fabric does, when the range is open
+func (s *testStub) GetStateByRange(startKey, endKey string) (shim.StateQueryIteratorInterface, error) {
+	if startKey == "" {
+		startKey = "\x01"
+	}
+	if endKey == "" {
+		endKey = string(utf8.MaxRune)
+	}
+	return s.MockStub.GetStateByRange(startKey, endKey)
+}
+
+func (s *testStub) GetStateByPartialCompositeKeyWithPagination(objectType string, attributes []string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	iterator, err := s.GetStateByPartialCompositeKey(objectType, attributes)
+	if err != nil {
+		return nil, nil, err
+	}
+	return paginate(iterator, pageSize, bookmark)
+}
+
+func (s *testStub) GetQueryResult(query string) (shim.StateQueryIteratorInterface, error) {
+	s.queries = append(s.queries, query)
+	return s.GetStateByPartialCompositeKey(reviewObjectType, nil)
+}
+
+func (s *testStub) GetQueryResultWithPagination(query string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	iterator, err := s.GetQueryResult(query)
+	if err != nil {
+		return nil, nil, err
+	}
+	return paginate(iterator, pageSize, bookmark)
+}
+
+func (s *testStub) GetHistoryForKey(key string) (shim.HistoryQueryIteratorInterface, error) {
+	modifications := slices.Clone(s.history[key])
+	slices.Reverse(modifications) // newest first, like Fabric
+	return &historyIterator{modifications: modifications}, nil
+}
+
+func (s *testStub) SetEvent(name string, payload []byte) error {
+	s.event = &pb.ChaincodeEvent{EventName: name, Payload: payload, TxId: s.TxID}
+	return nil
+}
+
+// paginate returns the pageSize results of iterator from bookmark, which is the key of the first one
+func paginate(iterator shim.StateQueryIteratorInterface, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	defer iterator.Close()
+
+	page := &kvIterator{}
+	metadata := &pb.QueryResponseMetadata{}
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return nil, nil, err
+		}
+		if kv.Key < bookmark {
+			continue
+		}
+		if int32(len(page.kvs)) == pageSize {
+			metadata.Bookmark = kv.Key
+			break
+		}
+		page.kvs = append(page.kvs, kv)
+	}
+	metadata.FetchedRecordsCount = int32(len(page.kvs))
+
+	return page, metadata, nil
+}
+
+// kvIterator iterates over a page of query results
+type kvIterator struct {
+	kvs []*queryresult.KV
+}
+
+func (it *kvIterator) HasNext() bool { return len(it.kvs) > 0 }
+func (it *kvIterator) Close() error  { return nil }
+
+func (it *kvIterator) Next() (*queryresult.KV, error) {
+	kv := it.kvs[0]
+	it.kvs = it.kvs[1:]
+	return kv, nil
+}
+
+// historyIterator iterates over the modifications of a key
+type historyIterator struct {
+	modifications []*queryresult.KeyModification
+}
+
+func (it *historyIterator) HasNext() bool { return len(it.modifications) > 0 }
+func (it *historyIterator) Close() error  { return nil }
+
+func (it *historyIterator) Next() (*queryresult.KeyModification, error) {
+	modification := it.modifications[0]
+	it.modifications = it.modifications[1:]
+	return modification, nil
+}
+
+func TestStubBuffersWrites(t *testing.T) {
+	s := newTestStub(t)
+	key := s.seed(Review{ID: "seeded"}, reviewObjectType, "seeded")
+
+	s.inTransaction(admin, func(ctx contractapi.TransactionContextInterface) error {
+		if err := ctx.GetStub().PutState("written", []byte("{}")); err != nil {
+			return err
+		}
+		if err := ctx.GetStub().DelState(key); err != nil {
+			return err
+		}
+		if value, err := ctx.GetStub().GetState("written"); err != nil || value != nil {
+			t.Errorf("read %s, %v written by the same transaction", value, err)
+		}
+		if value, err := ctx.GetStub().GetState(key); err != nil || value == nil {
+			t.Errorf("read %s, %v deleted by the same transaction", value, err)
+		}
+		return nil
+	})
+
+	if s.State["written"] == nil || s.State[key] != nil {
+		t.Error("the writes weren't committed")
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/edgeflare/fabreview/apierr"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/oklog/ulid/v2"
+)
+
+// Vote tallies follow the high-throughput pattern of fabric-samples: every vote writes a delta
+// under its own key instead of updating a shared counter, so many votes on the same review
+// can be endorsed in the same block. CompactVotes periodically folds the deltas into a checkpoint.
+const (
+	voteDeltaObjectType = "votedelta" // votedelta~reviewID~targetID~txID
+	voteTallyObjectType = "votetally" // votetally~reviewID~targetID
+)
+
+// VoteTally holds the vote counts of a review, or of a comment when CommentID is set
+type VoteTally struct {
+	ReviewID  string `json:"review_id"`
+	CommentID string `json:"comment_id,omitzero" metadata:",optional"`
+	Upvotes   int    `json:"upvotes"`
+	Downvotes int    `json:"downvotes"`
+	Score     int    `json:"score"` // upvotes - downvotes
+	// DocType and SchemaVersion identify the stored checkpoint, see schemaVersion. Absent in tallies read
+	DocType       string `json:"doc_type,omitzero" metadata:",optional"`
+	SchemaVersion int    `json:"schema_version,omitzero" metadata:",optional"`
+}
+
+// voteDelta is the change a single transaction made to a tally
+type voteDelta struct {
+	Upvotes   int `json:"upvotes"`
+	Downvotes int `json:"downvotes"`
+	// DocType and SchemaVersion identify the stored document, see schemaVersion
+	DocType       string `json:"doc_type,omitzero"`
+	SchemaVersion int    `json:"schema_version,omitzero"`
+}
+
+// targetID returns the ID of what is voted on or flagged: the comment if commentID is set, else the review
+func targetID(reviewID, commentID string) string {
+	if commentID != "" {
+		return commentID
+	}
+	return reviewID
+}
+
+// readVote returns a user's current vote on a review or comment, None if they haven't voted
+func readVote(ctx contractapi.TransactionContextInterface, reviewID, commentID, userID string) (VoteType, error) {
+	key, err := voteKey(ctx, reviewID, commentID, userID)
+	if err != nil {
+		return None, err
+	}
+
+	voteJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return None, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if voteJSON == nil {
+		return None, nil
+	}
+
+	var vote Vote
+	if err := decodeVote(voteJSON, &vote); err != nil {
+		return None, err
+	}
+
+	return vote.Value, nil
+}
+
+// recordVote stores a user's vote and writes the resulting tally delta.
+// Only the voter's own vote key is read, so votes from different users don't conflict.
+// The vote is stamped with the transaction time; the review or comment isn't touched, for the same reason.
+func recordVote(ctx contractapi.TransactionContextInterface, reviewID, commentID string, vote Vote) error {
+	previous, err := readVote(ctx, reviewID, commentID, vote.UserID)
+	if err != nil {
+		return err
+	}
+
+	vote.UpdatedAt, err = txTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := putVote(ctx, reviewID, commentID, vote); err != nil {
+		return err
+	}
+
+	delta := voteDelta{}
+	delta.add(previous, -1)
+	delta.add(vote.Value, 1)
+
+	return putVoteDelta(ctx, reviewID, commentID, delta)
+}
+
+// add counts n votes of the given value into the delta
+func (d *voteDelta) add(value VoteType, n int) {
+	switch value {
+	case Upvote:
+		d.Upvotes += n
+	case Downvote:
+		d.Downvotes += n
+	}
+}
+
+// putVoteDelta writes a delta keyed by the current transaction ID. Empty deltas aren't written
+func putVoteDelta(ctx contractapi.TransactionContextInterface, reviewID, commentID string, delta voteDelta) error {
+	if delta.Upvotes == 0 && delta.Downvotes == 0 {
+		return nil
+	}
+
+	key, err := ctx.GetStub().CreateCompositeKey(voteDeltaObjectType, []string{reviewID, targetID(reviewID, commentID), ctx.GetStub().GetTxID()})
+	if err != nil {
+		return err
+	}
+
+	delta.DocType, delta.SchemaVersion = voteDeltaObjectType, schemaVersion
+	deltaJSON, err := json.Marshal(delta)
+	if err != nil {
+		return fmt.Errorf("failed to marshal vote delta: %v", err)
+	}
+
+	return ctx.GetStub().PutState(key, deltaJSON)
+}
+
+// readVoteTally sums the checkpoint and the deltas written since. It also returns the delta keys it read
+func readVoteTally(ctx contractapi.TransactionContextInterface, reviewID, commentID string) (*VoteTally, []string, error) {
+	target := targetID(reviewID, commentID)
+	tally := &VoteTally{ReviewID: reviewID, CommentID: commentID}
+
+	checkpointKey, err := ctx.GetStub().CreateCompositeKey(voteTallyObjectType, []string{reviewID, target})
+	if err != nil {
+		return nil, nil, err
+	}
+	checkpointJSON, err := ctx.GetStub().GetState(checkpointKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if checkpointJSON != nil {
+		var checkpoint VoteTally
+		if err := decodeDocument(voteTallyObjectType, checkpointJSON, &checkpoint); err != nil {
+			return nil, nil, err
+		}
+		tally.Upvotes, tally.Downvotes = checkpoint.Upvotes, checkpoint.Downvotes
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(voteDeltaObjectType, []string{reviewID, target})
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		if closeErr := resultsIterator.Close(); closeErr != nil {
+			err = closeErr
+		}
+	}()
+
+	var deltaKeys []string
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		var delta voteDelta
+		if err := decodeDocument(voteDeltaObjectType, queryResponse.Value, &delta); err != nil {
+			return nil, nil, err
+		}
+		tally.Upvotes += delta.Upvotes
+		tally.Downvotes += delta.Downvotes
+		deltaKeys = append(deltaKeys, queryResponse.Key)
+	}
+
+	tally.Score = tally.Upvotes - tally.Downvotes
+
+	return tally, deltaKeys, nil
+}
+
+// GetVoteTally returns the vote counts of a review, or of one of its comments if commentID is provided
+func (s *ReviewContract) GetVoteTally(ctx contractapi.TransactionContextInterface, reviewID, commentID string) (*VoteTally, error) {
+	_, err := ulid.ParseStrict(reviewID)
+	if err != nil {
+		return nil, apierr.New(apierr.InvalidArgument, "reviewID isn't ULID %v", err)
+	}
+
+	tally, _, err := readVoteTally(ctx, reviewID, commentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read vote tally: %v", err)
+	}
+
+	return tally, nil
+}
+
+// CompactVotes folds the vote deltas of a review, or of one of its comments, into a single checkpoint.
+// Only admins may compact votes.
+func (s *ReviewContract) CompactVotes(ctx contractapi.TransactionContextInterface, reviewID, commentID string) (*VoteTally, error) {
+	_, err := ulid.ParseStrict(reviewID)
+	if err != nil {
+		return nil, apierr.New(apierr.InvalidArgument, "reviewID isn't ULID %v", err)
+	}
+
+	tally, deltaKeys, err := readVoteTally(ctx, reviewID, commentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read vote tally: %v", err)
+	}
+
+	checkpointKey, err := ctx.GetStub().CreateCompositeKey(voteTallyObjectType, []string{reviewID, targetID(reviewID, commentID)})
+	if err != nil {
+		return nil, err
+	}
+	checkpoint := *tally
+	checkpoint.DocType, checkpoint.SchemaVersion = voteTallyObjectType, schemaVersion
+	tallyJSON, err := json.Marshal(checkpoint)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal vote tally: %v", err)
+	}
+	if err := ctx.GetStub().PutState(checkpointKey, tallyJSON); err != nil {
+		return nil, fmt.Errorf("failed to write vote tally: %v", err)
+	}
+
+	for _, key := range deltaKeys {
+		if err := ctx.GetStub().DelState(key); err != nil {
+			return nil, fmt.Errorf("failed to delete vote delta: %v", err)
+		}
+	}
+
+	return tally, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/edgeflare/fabreview/apierr"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// voteTally returns the tally of a review or comment, as GetVoteTally does
+func (s *testStub) voteTally(reviewID, commentID string) VoteTally {
+	s.t.Helper()
+
+	var tally VoteTally
+	s.mustInvokeJSON(&tally, reader, "GetVoteTally", reviewID, commentID)
+	return tally
+}
+
+func TestVoteTally(t *testing.T) {
+	s := newTestStub(t)
+	id := s.createReview(alice, "example.com")
+
+	s.mustInvoke(bob, "Vote", id, "-1", "")
+	s.mustInvoke(carol, "Vote", id, "1", "")
+	if tally := s.voteTally(id, ""); tally.Upvotes != 2 || tally.Downvotes != 1 || tally.Score != 1 {
+		t.Errorf("got %+v, want 2 up and 1 down", tally)
+	}
+
+	// changing or withdrawing a vote replaces it
+	s.mustInvoke(bob, "Vote", id, "1", "")
+	s.mustInvoke(carol, "Vote", id, "0", "")
+	if tally := s.voteTally(id, ""); tally.Upvotes != 2 || tally.Downvotes != 0 || tally.Score != 2 {
+		t.Errorf("got %+v, want 2 up", tally)
+	}
+
+	s.wantError(apierr.InvalidArgument, bob, "Vote", id, "2", "")
+	s.wantError(apierr.InvalidArgument, reader, "GetVoteTally", "not-a-ulid", "")
+}
+
+func TestCommentVoteTally(t *testing.T) {
+	s := newTestStub(t)
+	id := s.createReview(alice, "example.com")
+	commentID := s.newID()
+	s.mustInvoke(bob, "AddComment", id, commentID, "Agreed.")
+	s.mustInvoke(alice, "Vote", id, "-1", commentID)
+
+	if tally := s.voteTally(id, commentID); tally.Upvotes != 1 || tally.Downvotes != 1 || tally.CommentID != commentID {
+		t.Errorf("got %+v, want the author's upvote and alice's downvote", tally)
+	}
+	if tally := s.voteTally(id, ""); tally.Upvotes != 1 || tally.Downvotes != 0 {
+		t.Errorf("comment votes counted towards the review: %+v", tally)
+	}
+}
+
+func TestCompactVotes(t *testing.T) {
+	s := newTestStub(t)
+	id := s.createReview(alice, "example.com")
+	s.mustInvoke(bob, "Vote", id, "-1", "")
+	s.mustInvoke(carol, "Vote", id, "-1", "")
+	want := s.voteTally(id, "")
+
+	s.wantError(apierr.Forbidden, alice, "CompactVotes", id, "")
+
+	var compacted VoteTally
+	s.mustInvokeJSON(&compacted, admin, "CompactVotes", id, "")
+	if compacted != want {
+		t.Errorf("compacted into %+v, want %+v", compacted, want)
+	}
+	if n := s.countKeys(voteDeltaObjectType, id); n != 0 {
+		t.Errorf("%d deltas left after compaction", n)
+	}
+	checkpoint, err := shim.CreateCompositeKey(voteTallyObjectType, []string{id, id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.State[checkpoint] == nil {
+		t.Error("no checkpoint written")
+	}
+
+	// votes after the checkpoint add to it
+	s.mustInvoke(bob, "Vote", id, "1", "")
+	if tally := s.voteTally(id, ""); tally.Upvotes != 2 || tally.Downvotes != 1 {
+		t.Errorf("got %+v after compaction, want 2 up and 1 down", tally)
+	}
+}
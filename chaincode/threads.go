@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/edgeflare/fabreview/apierr"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/oklog/ulid/v2"
+)
+
+// Replies are comments with a parent_id, stored like any other comment. A deleted comment that still
+// has replies is kept as a placeholder so the thread stays whole, and removed with its last reply.
+const (
+	maxCommentDepth    = 5 // replies to replies nest at most this deep, top-level comments being at depth 0
+	deletedPlaceholder = "[deleted]"
+)
+
+// CommentThread is a comment with its replies, oldest first
+type CommentThread struct {
+	Comment Comment         `json:"comment"`
+	Replies []CommentThread `json:"replies"`
+}
+
+// AddReply adds a reply to a comment of the same review
+func (s *ReviewContract) AddReply(ctx contractapi.TransactionContextInterface, reviewID, parentID, commentID, commentText string) error {
+	_, err := ulid.ParseStrict(parentID)
+	if err != nil {
+		return apierr.New(apierr.InvalidArgument, "parentID isn't ULID %v", err)
+	}
+
+	if err := checkIDTime(ctx, "commentID", commentID); err != nil {
+		return err
+	}
+
+	return s.addComment(ctx, reviewID, parentID, commentID, commentText)
+}
+
+// readParent returns the comment a reply is added to, which must accept replies
+func readParent(ctx contractapi.TransactionContextInterface, reviewID, parentID string) (*Comment, error) {
+	parent, err := readComment(ctx, reviewID, parentID)
+	if err != nil {
+		return nil, err
+	}
+	if parent == nil {
+		return nil, apierr.New(apierr.NotFound, "parent comment with ID %s not found in review %s", parentID, reviewID)
+	}
+	if parent.Deleted {
+		return nil, apierr.New(apierr.Conflict, "comment with ID %s was deleted", parentID)
+	}
+	if parent.Hidden != nil {
+		return nil, apierr.New(apierr.Conflict, "comment with ID %s is hidden by moderation", parentID)
+	}
+	if parent.Depth >= maxCommentDepth {
+		return nil, apierr.New(apierr.InvalidArgument, "replies can't be nested more than %d levels deep", maxCommentDepth)
+	}
+	return parent, nil
+}
+
+// hasReplies reports whether a comment has replies other than except
+func hasReplies(ctx contractapi.TransactionContextInterface, reviewID, commentID, except string) (bool, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(commentObjectType, []string{reviewID})
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		if closeErr := resultsIterator.Close(); closeErr != nil {
+			err = closeErr
+		}
+	}()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return false, err
+		}
+
+		var comment Comment
+		if err := decodeComment(queryResponse.Value, &comment); err != nil {
+			return false, err
+		}
+		if comment.ParentID == commentID && comment.ID != except {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// pruneDeleted removes the placeholders above a removed comment that are left without replies,
+// along with their votes and flags
+func pruneDeleted(ctx contractapi.TransactionContextInterface, reviewID, parentID, removedID string) error {
+	for parentID != "" {
+		parent, err := readComment(ctx, reviewID, parentID)
+		if err != nil {
+			return err
+		}
+		if parent == nil || !parent.Deleted {
+			return nil
+		}
+
+		replied, err := hasReplies(ctx, reviewID, parentID, removedID)
+		if err != nil {
+			return err
+		}
+		if replied {
+			return nil
+		}
+
+		key, err := commentKey(ctx, reviewID, parentID)
+		if err != nil {
+			return err
+		}
+		if err := ctx.GetStub().DelState(key); err != nil {
+			return fmt.Errorf("failed to delete comment: %v", err)
+		}
+		// flags, and votes written before it was deleted, go with the placeholder
+		if err := deleteCommentInteractions(ctx, reviewID, parentID); err != nil {
+			return fmt.Errorf("failed to delete comment votes: %v", err)
+		}
+
+		removedID, parentID = parentID, parent.ParentID
+	}
+
+	return nil
+}
+
+// GetCommentThread returns a comment of a review with its replies, nested
+func (s *ReviewContract) GetCommentThread(ctx contractapi.TransactionContextInterface, reviewID, commentID string) (*CommentThread, error) {
+	_, err := ulid.ParseStrict(reviewID)
+	if err != nil {
+		return nil, apierr.New(apierr.InvalidArgument, "reviewID isn't ULID %v", err)
+	}
+	_, err = ulid.ParseStrict(commentID)
+	if err != nil {
+		return nil, apierr.New(apierr.InvalidArgument, "commentID isn't ULID %v", err)
+	}
+
+	if _, err := s.readActiveReview(ctx, reviewID); err != nil {
+		return nil, err
+	}
+
+	comments, err := readComments(ctx, reviewID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read comments of review %s: %v", reviewID, err)
+	}
+
+	// comments come sorted by ID, so replies are appended oldest first
+	var root *Comment
+	replies := map[string][]Comment{}
+	for i, comment := range comments {
+		if comment.ID == commentID {
+			root = &comments[i]
+		}
+		if comment.ParentID != "" {
+			replies[comment.ParentID] = append(replies[comment.ParentID], comment)
+		}
+	}
+	if root == nil {
+		return nil, apierr.New(apierr.NotFound, "comment with ID %s not found in review %s", commentID, reviewID)
+	}
+
+	var build func(comment Comment) CommentThread
+	build = func(comment Comment) CommentThread {
+		thread := CommentThread{Comment: comment, Replies: []CommentThread{}}
+		for _, reply := range replies[comment.ID] {
+			thread.Replies = append(thread.Replies, build(reply))
+		}
+		return thread
+	}
+	thread := build(*root)
+
+	return &thread, nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/edgeflare/fabreview/apierr"
+)
+
+// commentThread returns a comment with its replies, as GetCommentThread does
+func (s *testStub) commentThread(reviewID, commentID string) CommentThread {
+	s.t.Helper()
+
+	var thread CommentThread
+	s.mustInvokeJSON(&thread, reader, "GetCommentThread", reviewID, commentID)
+	return thread
+}
+
+func TestCommentThread(t *testing.T) {
+	s := newTestStub(t)
+	id := s.createReview(alice, "example.com")
+	root := s.newID()
+	s.mustInvoke(bob, "AddComment", id, root, "Is this still true?")
+	first := s.newID()
+	s.mustInvoke(alice, "AddReply", id, root, first, "Yes.")
+	second := s.newID()
+	s.mustInvoke(carol, "AddReply", id, root, second, "Not in my team.")
+	nested := s.newID()
+	s.mustInvoke(bob, "AddReply", id, first, nested, "Thanks.")
+
+	thread := s.commentThread(id, root)
+	if thread.Comment.ID != root || len(thread.Replies) != 2 || thread.Replies[0].Comment.ID != first || thread.Replies[1].Comment.ID != second {
+		t.Fatalf("got %+v", thread)
+	}
+	if replies := thread.Replies[0].Replies; len(replies) != 1 || replies[0].Comment.ID != nested || replies[0].Comment.Depth != 2 || replies[0].Comment.ParentID != first {
+		t.Errorf("got replies %+v", replies)
+	}
+
+	s.wantError(apierr.NotFound, bob, "AddReply", id, s.newID(), s.newID(), "To nothing.")
+	s.wantError(apierr.NotFound, bob, "AddReply", s.createReview(alice, "example.org"), root, s.newID(), "To another review's comment.")
+	s.wantError(apierr.NotFound, reader, "GetCommentThread", id, s.newID())
+}
+
+func TestCommentDepth(t *testing.T) {
+	s := newTestStub(t)
+	id := s.createReview(alice, "example.com")
+	parent := s.newID()
+	s.mustInvoke(bob, "AddComment", id, parent, "Depth 0.")
+	for range maxCommentDepth {
+		reply := s.newID()
+		s.mustInvoke(bob, "AddReply", id, parent, reply, "Deeper.")
+		parent = reply
+	}
+	s.wantError(apierr.InvalidArgument, bob, "AddReply", id, parent, s.newID(), "Too deep.")
+}
+
+func TestDeleteCommentWithReplies(t *testing.T) {
+	s := newTestStub(t)
+	id := s.createReview(alice, "example.com")
+	parent := s.newID()
+	s.mustInvoke(bob, "AddComment", id, parent, "Is this still true?")
+	reply := s.newID()
+	s.mustInvoke(alice, "AddReply", id, parent, reply, "Yes.")
+
+	s.mustInvoke(bob, "DeleteComment", id, parent)
+	thread := s.commentThread(id, parent)
+	if !thread.Comment.Deleted || thread.Comment.Comment != deletedPlaceholder || len(thread.Replies) != 1 {
+		t.Fatalf("got %+v, want a placeholder keeping the reply", thread)
+	}
+	s.wantError(apierr.Conflict, carol, "AddReply", id, parent, s.newID(), "To a deleted comment.")
+
+	// the placeholder goes with its last reply
+	s.mustInvoke(alice, "DeleteComment", id, reply)
+	if n := s.countKeys(commentObjectType, id); n != 0 {
+		t.Errorf("%d comments left after deleting the thread", n)
+	}
+}
+
+func TestPrunedPlaceholderTakesInteractions(t *testing.T) {
+	s := newTestStub(t)
+	id := s.createReview(alice, "example.com")
+	parent := s.newID()
+	s.mustInvoke(bob, "AddComment", id, parent, "Is this still true?")
+	reply := s.newID()
+	s.mustInvoke(alice, "AddReply", id, parent, reply, "Yes.")
+	s.mustInvoke(bob, "DeleteComment", id, parent)
+
+	// left on the placeholder by earlier versions, or flagged since
+	s.seed(Vote{UserID: "anon-voter", Value: Upvote}, commentVoteObjectType, id, parent, "anon-voter")
+	s.seed(VoteTally{ReviewID: id, CommentID: parent, Upvotes: 1, Score: 1}, voteDeltaObjectType, id, parent, "tx")
+	s.seed(VoteTally{ReviewID: id, CommentID: parent, Upvotes: 1, Score: 1}, voteTallyObjectType, id, parent)
+	s.mustInvoke(carol, "FlagComment", id, parent, string(FlagSpam))
+
+	s.mustInvoke(alice, "DeleteComment", id, reply)
+	for _, objectType := range []string{commentVoteObjectType, voteDeltaObjectType, voteTallyObjectType, flagObjectType, flagQueueObjectType} {
+		if n := s.countKeys(objectType, id, parent); n != 0 {
+			t.Errorf("%d %s keys left on the pruned placeholder", n, objectType)
+		}
+	}
+}
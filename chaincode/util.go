@@ -5,30 +5,16 @@ import (
 	"encoding/json"
 	"fmt"
 	"strings"
+	"time"
 
+	"github.com/edgeflare/fabreview/apierr"
 	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
 	"github.com/oklog/ulid/v2"
 )
 
-// reviewInput represents the input parameters for creating or updating a review
-type reviewInput struct {
-	ID        string
-	Title     string
-	Website   string
-	Summary   string
-	Rating    uint8
-	Country   string
-	State     string
-	Locality  string
-	Email     string
-	Phone     string
-	Positives string // JSON string of array
-	Negatives string // JSON string of array
-	ExtraInfo string // JSON string of object
-}
-
-// commonName gets the common name from the client's certificate
+// commonName gets the common name from the client's certificate, which served as user ID before userID
 func (s *ReviewContract) commonName(ctx contractapi.TransactionContextInterface) (string, error) {
 	clientIdentity, err := cid.New(ctx.GetStub())
 	if err != nil {
@@ -41,140 +27,176 @@ func (s *ReviewContract) commonName(ctx contractapi.TransactionContextInterface)
 	return cert.Subject.CommonName, nil
 }
 
-// verifyExistsAndOwner checks if a review exists and if the caller is the owner
-func (s *ReviewContract) verifyExistsAndOwner(ctx contractapi.TransactionContextInterface, id string) (*Review, error) {
-	existingReview, err := s.ReadReview(ctx, id)
+// readReview returns the review document stored with given id, without its separately stored votes and comments
+func (s *ReviewContract) readReview(ctx contractapi.TransactionContextInterface, id string) (*Review, error) {
+	reviewJSON, err := getReviewState(ctx, id)
 	if err != nil {
-		return nil, fmt.Errorf("the review %s does not exist: %w", id, err)
+		return nil, err
+	}
+	if reviewJSON == nil {
+		return nil, apierr.New(apierr.NotFound, "the review %s does not exist", id)
 	}
 
-	userCN, err := s.commonName(ctx)
+	var review Review
+	err = decodeReview(reviewJSON, &review)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get user identity: %v", err)
+		return nil, err
 	}
 
-	if existingReview.UserID != userCN {
-		return nil, fmt.Errorf("unauthorized: only the original review creator can update this review")
-	}
+	return &review, nil
+}
 
-	return existingReview, nil
+// readActiveReview returns the review document stored with given id, or an error if it doesn't exist, is deleted or hidden
+func (s *ReviewContract) readActiveReview(ctx contractapi.TransactionContextInterface, id string) (*Review, error) {
+	review, err := s.readReview(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if review.Tombstone != nil {
+		return nil, apierr.New(apierr.NotFound, "the review %s has been deleted", id)
+	}
+	if review.Hidden != nil {
+		return nil, apierr.New(apierr.NotFound, "the review %s is hidden by moderation", id)
+	}
+	return review, nil
 }
 
-// validateStringLength validates string length is within limits
-func validateStringLength(s string, max int) error {
-	if s == "" {
-		return fmt.Errorf("value cannot be empty")
+// verifyExistsAndOwner checks if a review exists, isn't deleted and if the caller is the owner
+func (s *ReviewContract) verifyExistsAndOwner(ctx contractapi.TransactionContextInterface, id string) (*Review, error) {
+	existingReview, err := s.readActiveReview(ctx, id)
+	if err != nil {
+		return nil, err
 	}
 
-	// Special case for country codes
-	if max == 2 {
-		if len(s) != 2 {
-			return fmt.Errorf("country code must be exactly 2 characters")
-		}
-		return nil
+	if err := s.verifyOwner(ctx, existingReview); err != nil {
+		return nil, err
+	}
+
+	return existingReview, nil
+}
+
+// verifyOwner checks if the caller is the owner of review
+func (s *ReviewContract) verifyOwner(ctx contractapi.TransactionContextInterface, review *Review) error {
+	userID, err := s.authorID(ctx, review.ID)
+	if err != nil {
+		return err
 	}
 
-	// Normal case for other string lengths
-	if max > 0 && len(s) > max {
-		return fmt.Errorf("value exceeds %d chars", max)
+	if review.UserID != userID {
+		return apierr.New(apierr.Forbidden, "unauthorized: only the original review creator can update this review")
 	}
 
 	return nil
 }
 
-// validateRating validates that rating is between 1 and 10
-func validateRating(rating uint8) error {
-	if rating < 1 || rating > 10 {
-		return fmt.Errorf("rating must be between 1 and 10")
+// txTime returns the transaction timestamp, which unlike the local clock is the same on every endorser
+func txTime(ctx contractapi.TransactionContextInterface) (time.Time, error) {
+	timestamp, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to get transaction timestamp: %v", err)
 	}
-	return nil
+	return timestamp.AsTime(), nil
 }
 
-// validateInput validates all input parameters for a review
-func (s *ReviewContract) validateInput(input *reviewInput, isCreate bool) error {
-	if isCreate {
-		_, err := ulid.ParseStrict(input.ID)
-		if err != nil {
-			return fmt.Errorf("id isn't ULID: %w", err)
-		}
+// restoreWindowExpired reports whether the configured restore window of a deleted review has passed
+func restoreWindowExpired(ctx contractapi.TransactionContextInterface, tombstone *Tombstone) (bool, error) {
+	config, err := readConfig(ctx)
+	if err != nil {
+		return false, err
 	}
 
-	// Only validate non-empty fields (useful for updates where some fields might be empty)
-	if input.Title != "" {
-		if err := validateStringLength(input.Title, 128); err != nil {
-			return fmt.Errorf("invalid title: %w", err)
-		}
+	now, err := txTime(ctx)
+	if err != nil {
+		return false, err
 	}
 
-	if input.Website != "" {
-		// Trim http(s):// and trailing slashes from website
-		trimmedWebsite := input.Website
-		trimmedWebsite = strings.TrimPrefix(trimmedWebsite, "http://")
-		trimmedWebsite = strings.TrimPrefix(trimmedWebsite, "https://")
-		trimmedWebsite = strings.TrimSuffix(trimmedWebsite, "/")
-
-		if err := validateStringLength(trimmedWebsite, 64); err != nil {
-			return fmt.Errorf("invalid website: %w", err)
-		}
+	window := time.Duration(config.RestoreWindowHours) * time.Hour
+	return now.After(tombstone.DeletedAt.Add(window)), nil
+}
 
-		// Update the input with the trimmed website
-		input.Website = trimmedWebsite
-	}
+// validateInput validates the fields of a review input. When complete, as on create, the required fields
+// must be set; otherwise empty fields are skipped, as they leave the stored value unchanged.
+// The website and country are canonicalized in place
+func (s *ReviewContract) validateInput(input *ReviewInput, complete bool) error {
+	v := &validator{}
 
-	if input.Summary != "" {
-		if err := validateStringLength(input.Summary, 4096); err != nil {
-			return fmt.Errorf("invalid summary: %w", err)
+	if complete {
+		if _, err := ulid.ParseStrict(input.ID); err != nil {
+			v.add("id", apierr.InvalidFormat, "must be a ULID")
 		}
 	}
 
-	if input.Rating > 0 {
-		if err := validateRating(input.Rating); err != nil {
-			return err
+	text := func(field, value string, rule textRule) {
+		if value != "" || complete {
+			v.text(field, value, rule)
 		}
 	}
+	text("title", input.Title, titleRule)
+	text("summary", input.Summary, summaryRule)
 
-	if input.Country != "" {
-		if err := validateStringLength(input.Country, 2); err != nil {
-			return fmt.Errorf("invalid country: %w", err)
+	if input.Website != "" {
+		domain, err := canonicalWebsite(input.Website)
+		if err != nil {
+			v.add("website", apierr.InvalidFormat, err.Error())
 		}
+		input.Website = cmp.Or(domain, input.Website)
+	} else if complete {
+		v.add("website", apierr.Required, "is required")
 	}
 
-	if input.State != "" {
-		if err := validateStringLength(input.State, 32); err != nil {
-			return fmt.Errorf("invalid state: %w", err)
-		}
+	if input.Rating != 0 || complete {
+		v.rating("rating", input.Rating)
 	}
 
+	input.Country = strings.ToUpper(input.Country)
+	text("country", input.Country, countryRule)
+
+	// state and locality are optional
+	if input.State != "" {
+		v.text("state", input.State, regionRule)
+	}
 	if input.Locality != "" {
-		if err := validateStringLength(input.Locality, 32); err != nil {
-			return fmt.Errorf("invalid locality: %w", err)
-		}
+		v.text("locality", input.Locality, regionRule)
 	}
 
-	// Validate JSON arrays if provided
-	if input.Positives != "" {
-		var positives []string
-		if err := json.Unmarshal([]byte(input.Positives), &positives); err != nil {
-			return fmt.Errorf("failed to unmarshal positives: %v", err)
-		}
+	v.list("positives", input.Positives, tagsRule)
+	v.list("negatives", input.Negatives, tagsRule)
+	v.dict("extra_info", input.ExtraInfo, extraInfoRule)
+
+	return v.err()
+}
+
+// reviewInputFromArgs builds a ReviewInput from the positional arguments of CreateReview and UpdateReview,
+// where positives, negatives and extraInfo are JSON strings. Contact details are refused, they're private
+func reviewInputFromArgs(id, title, website, summary, country, state, locality, positives, negatives, extraInfo string, rating uint8) (*ReviewInput, error) {
+	positivesSlice, err := parseSliceFromJSONString(positives)
+	if err != nil {
+		return nil, apierr.New(apierr.InvalidArgument, "invalid positives: %v", err)
 	}
 
-	if input.Negatives != "" {
-		var negatives []string
-		if err := json.Unmarshal([]byte(input.Negatives), &negatives); err != nil {
-			return fmt.Errorf("failed to unmarshal negatives: %v", err)
-		}
+	negativesSlice, err := parseSliceFromJSONString(negatives)
+	if err != nil {
+		return nil, apierr.New(apierr.InvalidArgument, "invalid negatives: %v", err)
 	}
 
-	// Validate extra info JSON if provided
-	if input.ExtraInfo != "" {
-		var extraInfo map[string]string
-		if err := json.Unmarshal([]byte(input.ExtraInfo), &extraInfo); err != nil {
-			return fmt.Errorf("invalid extra info JSON: %v", err)
-		}
+	extraInfoMap, err := parseMapFromJSONString(extraInfo)
+	if err != nil {
+		return nil, apierr.New(apierr.InvalidArgument, "invalid extra info: %v", err)
 	}
 
-	return nil
+	return &ReviewInput{
+		ID:        id,
+		Title:     title,
+		Website:   website,
+		Summary:   summary,
+		Rating:    rating,
+		Country:   country,
+		State:     state,
+		Locality:  locality,
+		Positives: positivesSlice,
+		Negatives: negativesSlice,
+		ExtraInfo: extraInfoMap,
+	}, nil
 }
 
 // parseSliceFromJSONString converts JSON strings to string arrays
@@ -208,25 +230,18 @@ func parseMapFromJSONString(jsonStr string) (map[string]string, error) {
 }
 
 // buildReviewFromInput creates a Review object from input parameters
-func (s *ReviewContract) buildReviewFromInput(ctx contractapi.TransactionContextInterface, input *reviewInput, existingReview *Review) (*Review, error) {
-	userCN, err := s.commonName(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get user identity: %v", err)
-	}
-
-	positives, err := parseSliceFromJSONString(input.Positives)
+func (s *ReviewContract) buildReviewFromInput(ctx contractapi.TransactionContextInterface, input *ReviewInput, existingReview *Review) (*Review, error) {
+	userID, err := s.authorID(ctx, input.ID)
 	if err != nil {
-		return nil, fmt.Errorf("invalid positives: %w", err)
+		return nil, err
 	}
 
-	negatives, err := parseSliceFromJSONString(input.Negatives)
-	if err != nil {
-		return nil, fmt.Errorf("invalid negatives: %w", err)
+	positives, negatives, extraInfo := input.Positives, input.Negatives, input.ExtraInfo
+	if positives == nil {
+		positives = []string{}
 	}
-
-	extraInfo, err := parseMapFromJSONString(input.ExtraInfo)
-	if err != nil {
-		return nil, fmt.Errorf("invalid extra info: %w", err)
+	if negatives == nil {
+		negatives = []string{}
 	}
 
 	// If it's an update operation (existingReview is not nil)
@@ -247,7 +262,7 @@ func (s *ReviewContract) buildReviewFromInput(ctx contractapi.TransactionContext
 		}
 
 		// Create new review with mixed existing and new values
-		return &Review{
+		review := &Review{
 			ID:        input.ID,
 			Title:     cmp.Or(input.Title, existingReview.Title),
 			Website:   cmp.Or(input.Website, existingReview.Website),
@@ -256,19 +271,36 @@ func (s *ReviewContract) buildReviewFromInput(ctx contractapi.TransactionContext
 			Country:   cmp.Or(input.Country, existingReview.Country),
 			State:     cmp.Or(input.State, existingReview.State),
 			Locality:  cmp.Or(input.Locality, existingReview.Locality),
-			Email:     cmp.Or(input.Email, existingReview.Email),
-			Phone:     cmp.Or(input.Phone, existingReview.Phone),
 			Positives: updatedPositives,
 			Negatives: updatedNegatives,
 			ExtraInfo: updatedExtraInfo,
-			Votes:     existingReview.Votes,
-			Comments:  existingReview.Comments,
+			Votes:     existingReview.Votes,    // only embedded in documents not yet migrated by MigrateInteractions
+			Comments:  existingReview.Comments, // idem
 			UserID:    existingReview.UserID,
-		}, nil
+			CreatedAt: existingReview.CreatedAt,
+			Revision:  existingReview.Revision,
+
+			PrivateCollection: existingReview.PrivateCollection,
+			PrivateHash:       existingReview.PrivateHash,
+			DocType:           reviewDocType,
+			SchemaVersion:     schemaVersion,
+		}
+		if err := touchReview(ctx, review); err != nil {
+			return nil, err
+		}
+		if err := linkEntity(ctx, review); err != nil {
+			return nil, err
+		}
+		return review, nil
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return nil, err
 	}
 
 	// Create new review for create operation
-	return &Review{
+	review := &Review{
 		ID:        input.ID,
 		Title:     input.Title,
 		Website:   input.Website,
@@ -277,13 +309,113 @@ func (s *ReviewContract) buildReviewFromInput(ctx contractapi.TransactionContext
 		Country:   input.Country,
 		State:     input.State,
 		Locality:  input.Locality,
-		Email:     cmp.Or(input.Email, NOT_SUPPLIED),
-		Phone:     cmp.Or(input.Phone, NOT_SUPPLIED),
 		Positives: positives,
 		Negatives: negatives,
 		ExtraInfo: extraInfo,
-		Votes:     []Vote{{UserID: userCN, Value: 1}},
-		Comments:  []Comment{},
-		UserID:    userCN,
-	}, nil
+		UserID:    userID,
+		CreatedAt: now,
+		UpdatedAt: now,
+		Revision:  1,
+
+		DocType:       reviewDocType,
+		SchemaVersion: schemaVersion,
+	}
+	if err := linkEntity(ctx, review); err != nil {
+		return nil, err
+	}
+	return review, nil
+}
+
+// touchReview records an update of review at the transaction time
+func touchReview(ctx contractapi.TransactionContextInterface, review *Review) error {
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	// reviews written before timestamps were recorded were created at the time of their ID
+	if review.CreatedAt.IsZero() {
+		review.CreatedAt = idTime(review.ID)
+	}
+	review.UpdatedAt = now
+	review.Edited = true
+	review.Revision = max(review.Revision, 1) + 1
+
+	return nil
+}
+
+// idTime returns the time encoded in a ULID, the zero time if id isn't one
+func idTime(id string) time.Time {
+	parsed, err := ulid.ParseStrict(id)
+	if err != nil {
+		return time.Time{}
+	}
+	return ulid.Time(parsed.Time()).UTC()
+}
+
+// checkIDTime refuses a ULID whose time is further from the transaction time than the configured tolerance,
+// so clients can't back-date, or post-date, what they create
+func checkIDTime(ctx contractapi.TransactionContextInterface, name, id string) error {
+	parsed, err := ulid.ParseStrict(id)
+	if err != nil {
+		return apierr.New(apierr.InvalidArgument, "%s isn't ULID %v", name, err)
+	}
+
+	config, err := readConfig(ctx)
+	if err != nil {
+		return err
+	}
+	if config.IDTimeToleranceSeconds == 0 {
+		return nil
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	tolerance := time.Duration(config.IDTimeToleranceSeconds) * time.Second
+	created := ulid.Time(parsed.Time())
+	if created.Before(now.Add(-tolerance)) || created.After(now.Add(tolerance)) {
+		return apierr.New(apierr.InvalidArgument, "the time of %s %s is more than %v away from the transaction time %s",
+			name, created.UTC().Format(time.RFC3339), tolerance, now.UTC().Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+// constructQueryResultFromIterator reads all reviews from the iterator into a slice of QueryResult.
+// Votes and comments are only read when includeInteractions is true.
+func constructQueryResultFromIterator(ctx contractapi.TransactionContextInterface, resultsIterator shim.StateQueryIteratorInterface, includeInteractions bool) ([]QueryResult, error) {
+	results := []QueryResult{}
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var review Review
+		err = decodeReview(queryResponse.Value, &review)
+		if err != nil {
+			return nil, err
+		}
+
+		// deleted and moderated reviews are hidden from listings
+		if review.Tombstone != nil || review.Hidden != nil {
+			continue
+		}
+
+		if includeInteractions {
+			if err := readInteractions(ctx, &review); err != nil {
+				return nil, err
+			}
+		} else {
+			review.Votes = nil
+			review.Comments = nil
+		}
+
+		results = append(results, QueryResult{Key: review.ID, Record: &review})
+	}
+
+	return results, nil
 }
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"crypto/rand"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/edgeflare/fabreview/apierr"
+	"github.com/oklog/ulid/v2"
+)
+
+func TestReviewTimestamps(t *testing.T) {
+	s := newTestStub(t)
+	id := s.createReview(alice, "example.com")
+	created := s.now
+
+	review := s.readReview(id)
+	if !review.CreatedAt.Equal(created) || !review.UpdatedAt.Equal(created) || review.Revision != 1 || review.Edited {
+		t.Errorf("got %+v for a new review", review)
+	}
+
+	s.advance(72 * time.Hour)
+	s.mustInvoke(alice, "PatchReview", toJSON(t, ReviewPatch{ID: id, Title: "Edited later"}))
+	edited := s.now
+	review = s.readReview(id)
+	if !review.CreatedAt.Equal(created) || !review.UpdatedAt.Equal(edited) || review.Revision != 2 || !review.Edited {
+		t.Errorf("got %+v after an edit", review)
+	}
+}
+
+func TestCommentTimestamps(t *testing.T) {
+	s := newTestStub(t)
+	id := s.createReview(alice, "example.com")
+	commentID := s.newID()
+	s.mustInvoke(bob, "AddComment", id, commentID, "Agreed.")
+	created := s.now
+
+	s.advance(time.Hour)
+	s.mustInvoke(bob, "EditComment", id, commentID, "Agreed, mostly.")
+	edited := s.now
+	s.mustInvoke(carol, "Vote", id, "1", "")
+	voted := s.now
+
+	review := s.readReview(id)
+	comment := review.Comments[0]
+	if !comment.CreatedAt.Equal(created) || !comment.UpdatedAt.Equal(edited) || comment.Revision != 2 || !comment.Edited {
+		t.Errorf("got comment %+v", comment)
+	}
+	if !slices.ContainsFunc(review.Votes, func(vote Vote) bool { return vote.UpdatedAt.Equal(voted) }) {
+		t.Errorf("got votes %+v", review.Votes)
+	}
+}
+
+func TestCheckIDTime(t *testing.T) {
+	s := newTestStub(t)
+	idAt := func(d time.Duration) string {
+		return ulid.MustNew(ulid.Timestamp(s.now.Add(time.Second+d)), rand.Reader).String()
+	}
+
+	for _, id := range []string{idAt(-time.Hour), idAt(time.Hour), "not-a-ulid"} {
+		input := s.reviewInput("example.com")
+		input.ID = id
+		s.wantError(apierr.InvalidArgument, alice, "CreateReviewV2", toJSON(t, input))
+	}
+	s.wantError(apierr.InvalidArgument, bob, "AddComment", s.createReview(alice, "example.com"), idAt(-time.Hour), "Back-dated.")
+
+	// a tolerance of 0 disables the check
+	s.mustInvoke(admin, "SetConfig", toJSON(t, Config{FlagThreshold: 3}))
+	input := s.reviewInput("example.org")
+	input.ID = idAt(-time.Hour)
+	s.mustInvoke(alice, "CreateReviewV2", toJSON(t, input))
+}
+
+func TestIDTime(t *testing.T) {
+	at := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	if got := idTime(ulid.MustNew(ulid.Timestamp(at), rand.Reader).String()); !got.Equal(at) {
+		t.Errorf("got %v, want %v", got, at)
+	}
+	if got := idTime("not-a-ulid"); !got.IsZero() {
+		t.Errorf("got %v for an invalid ID", got)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"net/mail"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/edgeflare/fabreview/apierr"
+)
+
+// Inputs are checked against declarative rules, and every invalid field is reported at once in the
+// Fields of an apierr.InvalidArgument error, which clients parse to show each message next to its input.
+// Lengths count characters, ie runes, so Bangla text gets the same limits as English.
+
+// textRule declares the constraints on a string
+type textRule struct {
+	max    int               // in characters, 0 for no limit
+	format func(string) bool // nil for any text
+	hint   string            // describes the format, eg "an email address"
+}
+
+// listRule declares the constraints on a list of strings
+type listRule struct {
+	maxItems int
+	item     textRule
+}
+
+// mapRule declares the constraints on a string map
+type mapRule struct {
+	maxEntries int
+	key        textRule
+	value      textRule
+}
+
+var (
+	titleRule     = textRule{max: 128}
+	summaryRule   = textRule{max: 4096}
+	countryRule   = textRule{format: isCountryCode, hint: "an ISO 3166-1 alpha-2 country code, eg BD"}
+	regionRule    = textRule{max: 32} // state and locality
+	tagRule       = textRule{max: 32} // a positive or negative
+	tagsRule      = listRule{maxItems: 16, item: tagRule}
+	extraInfoRule = mapRule{maxEntries: 16, key: textRule{max: 32}, value: textRule{max: 256}}
+	emailRule     = textRule{max: 254, format: isEmail, hint: "an email address"}
+	phoneRule     = textRule{format: isE164, hint: "an E.164 phone number, eg +8801712345678"}
+	commentRule   = textRule{max: 4096} // comments and official responses
+	noteRule      = textRule{max: 256}  // reasons and moderator notes
+	nameRule      = textRule{max: 128}
+	mediaTypeRule = textRule{max: 128}
+)
+
+// check returns the code and message of the first constraint value breaks, empty if it's valid
+func (r textRule) check(value string) (code, message string) {
+	switch {
+	case value == "":
+		return apierr.Required, "is required"
+	case !utf8.ValidString(value):
+		return apierr.InvalidEncoding, "must be valid UTF-8"
+	case r.max > 0 && utf8.RuneCountInString(value) > r.max:
+		return apierr.TooLong, fmt.Sprintf("must be at most %d characters", r.max)
+	case r.format != nil && !r.format(value):
+		return apierr.InvalidFormat, "must be " + r.hint
+	}
+	return "", ""
+}
+
+// validator collects the errors of the fields of an input
+type validator struct {
+	errors []apierr.FieldError
+}
+
+// add records an invalid field
+func (v *validator) add(field, code, message string) {
+	v.errors = append(v.errors, apierr.FieldError{Field: field, Code: code, Message: message})
+}
+
+// text checks a string field
+func (v *validator) text(field, value string, rule textRule) {
+	if code, message := rule.check(value); code != "" {
+		v.add(field, code, message)
+	}
+}
+
+// list checks a list field and each of its items
+func (v *validator) list(field string, values []string, rule listRule) {
+	if len(values) > rule.maxItems {
+		v.add(field, apierr.TooMany, fmt.Sprintf("must have at most %d items", rule.maxItems))
+	}
+	for i, value := range values {
+		v.text(fmt.Sprintf("%s[%d]", field, i), value, rule.item)
+	}
+}
+
+// dict checks a map field, its keys and its values
+func (v *validator) dict(field string, values map[string]string, rule mapRule) {
+	if len(values) > rule.maxEntries {
+		v.add(field, apierr.TooMany, fmt.Sprintf("must have at most %d entries", rule.maxEntries))
+	}
+	// sorted, so every endorser reports the same errors
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		if code, message := rule.key.check(key); code != "" {
+			v.add(field+"."+key, code, "key "+message)
+			continue
+		}
+		v.text(field+"."+key, values[key], rule.value)
+	}
+}
+
+// rating checks a rating
+func (v *validator) rating(field string, rating uint8) {
+	if rating < 1 || rating > 10 {
+		v.add(field, apierr.OutOfRange, "must be between 1 and 10")
+	}
+}
+
+// err returns the collected errors as an InvalidArgument error, nil if there are none
+func (v *validator) err() error {
+	if len(v.errors) == 0 {
+		return nil
+	}
+	fields := make([]string, len(v.errors))
+	for i, fieldError := range v.errors {
+		fields[i] = fieldError.Field
+	}
+	return &apierr.Error{
+		Code:    apierr.InvalidArgument,
+		Message: "invalid " + strings.Join(fields, ", "),
+		Fields:  v.errors,
+	}
+}
+
+// validateText checks a single string field
+func validateText(field, value string, rule textRule) error {
+	v := &validator{}
+	v.text(field, value, rule)
+	return v.err()
+}
+
+// isCountryCode reports whether s is an ISO 3166-1 alpha-2 code
+func isCountryCode(s string) bool {
+	return len(s) == 2 && slices.Contains(countryCodes, s)
+}
+
+// countryCodes lists the officially assigned ISO 3166-1 alpha-2 codes
+var countryCodes = strings.Fields(`
+	AD AE AF AG AI AL AM AO AQ AR AS AT AU AW AX AZ BA BB BD BE BF BG BH BI BJ BL BM BN BO BQ BR BS BT BV BW BY BZ
+	CA CC CD CF CG CH CI CK CL CM CN CO CR CU CV CW CX CY CZ DE DJ DK DM DO DZ EC EE EG EH ER ES ET FI FJ FK FM FO FR
+	GA GB GD GE GF GG GH GI GL GM GN GP GQ GR GS GT GU GW GY HK HM HN HR HT HU ID IE IL IM IN IO IQ IR IS IT JE JM JO JP
+	KE KG KH KI KM KN KP KR KW KY KZ LA LB LC LI LK LR LS LT LU LV LY MA MC MD ME MF MG MH MK ML MM MN MO MP MQ MR MS MT
+	MU MV MW MX MY MZ NA NC NE NF NG NI NL NO NP NR NU NZ OM PA PE PF PG PH PK PL PM PN PR PS PT PW PY QA RE RO RS RU RW
+	SA SB SC SD SE SG SH SI SJ SK SL SM SN SO SR SS ST SV SX SY SZ TC TD TF TG TH TJ TK TL TM TN TO TR TT TV TW TZ UA UG
+	UM US UY UZ VA VC VE VG VI VN VU WF WS YE YT ZA ZM ZW`)
+
+// isEmail reports whether s is a bare email address, without display name
+func isEmail(s string) bool {
+	address, err := mail.ParseAddress(s)
+	return err == nil && address.Address == s
+}
+
+var e164 = regexp.MustCompile(`^\+[1-9][0-9]{1,14}$`)
+
+// isE164 reports whether s is a phone number in E.164 format
+func isE164(s string) bool {
+	return e164.MatchString(s)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/edgeflare/fabreview/apierr"
+)
+
+func TestTextRule(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		rule  textRule
+		want  string
+	}{
+		{"bangla at the limit", strings.Repeat("বা", 2048), summaryRule, ""},
+		{"bangla over the limit", strings.Repeat("বা", 2048) + "ং", summaryRule, apierr.TooLong},
+		{"empty", "", titleRule, apierr.Required},
+		{"invalid UTF-8", "\xff", titleRule, apierr.InvalidEncoding},
+		{"country", "BD", countryRule, ""},
+		{"unassigned country", "XX", countryRule, apierr.InvalidFormat},
+		{"lowercase country", "bd", countryRule, apierr.InvalidFormat},
+		{"email", "hr@example.com", emailRule, ""},
+		{"email with a name", "HR <hr@example.com>", emailRule, apierr.InvalidFormat},
+		{"E.164 phone", "+8801712345678", phoneRule, ""},
+		{"local phone", "01712345678", phoneRule, apierr.InvalidFormat},
+	}
+	for _, tt := range tests {
+		if code, _ := tt.rule.check(tt.value); code != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, code, tt.want)
+		}
+	}
+}
+
+func TestValidator(t *testing.T) {
+	v := &validator{}
+	v.list("positives", []string{"pay", strings.Repeat("x", 33)}, tagsRule)
+	v.dict("extra_info", map[string]string{"b": "", "a": "ok", strings.Repeat("k", 33): "v"}, extraInfoRule)
+	v.rating("rating", 0)
+
+	err, _ := v.err().(*apierr.Error)
+	want := []apierr.FieldError{
+		{Field: "positives[1]", Code: apierr.TooLong, Message: "must be at most 32 characters"},
+		{Field: "extra_info.b", Code: apierr.Required, Message: "is required"},
+		{Field: "extra_info." + strings.Repeat("k", 33), Code: apierr.TooLong, Message: "key must be at most 32 characters"},
+		{Field: "rating", Code: apierr.OutOfRange, Message: "must be between 1 and 10"},
+	}
+	if err == nil || err.Code != apierr.InvalidArgument || !reflect.DeepEqual(err.Fields, want) {
+		t.Errorf("got %+v, want fields %+v", err, want)
+	}
+	if (&validator{}).err() != nil {
+		t.Error("an empty validator returned an error")
+	}
+}
+
+func TestCreateReviewFieldErrors(t *testing.T) {
+	s := newTestStub(t)
+	input := s.reviewInput("example.com")
+	input.Title, input.Country, input.Negatives = "", "ZZ", make([]string, 17)
+
+	err := s.invokeError(alice, "CreateReviewV2", toJSON(t, input))
+	fields := map[string]string{}
+	for _, field := range err.Fields {
+		fields[field.Field] = field.Code
+	}
+	for field, code := range map[string]string{"title": apierr.Required, "country": apierr.InvalidFormat, "negatives": apierr.TooMany} {
+		if fields[field] != code {
+			t.Errorf("got %q for %s, want %q in %+v", fields[field], field, code, err)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+// Package events defines the payloads of the chaincode events emitted by the fabreview ReviewContract.
+//
+// Every transaction that changes a review, comment or vote calls SetEvent once, with the event
+// Name as the event name and a JSON encoded Event as the payload. Off-chain consumers can listen
+// for these instead of polling the ledger, and decode the payload with:
+//
+//	var event events.Event
+//	err := json.Unmarshal(chaincodeEvent.Payload, &event)
+//
+// Fabric keeps only the last event set by a transaction, so a transaction that changes several
+// reviews at once (eg InitLedger) emits the event of its last change only.
+package events
+
+// Version is the version of the Event payload schema. It's bumped on incompatible changes.
+const Version = 1
+
+// Name identifies the kind of change an event reports
+type Name string
+
+const (
+	ReviewCreated   Name = "ReviewCreated"
+	ReviewUpdated   Name = "ReviewUpdated"
+	ReviewDeleted   Name = "ReviewDeleted" // soft delete, the review can still be restored
+	ReviewRestored  Name = "ReviewRestored"
+	ReviewPurged    Name = "ReviewPurged" // removed from world state for good
+	CommentAdded    Name = "CommentAdded"
+	CommentEdited   Name = "CommentEdited"
+	CommentDeleted  Name = "CommentDeleted"
+	Voted           Name = "Voted"
+	ReviewFlagged   Name = "ReviewFlagged"
+	CommentFlagged  Name = "CommentFlagged"
+	FlagResolved    Name = "FlagResolved"    // a moderator dismissed or upheld the flags
+	ContentRestored Name = "ContentRestored" // a moderator showed hidden content again
+	// the reviewed entity posted or replaced its response to a review
+	OfficialResponsePosted Name = "OfficialResponsePosted"
+	// the author anchored the hash of a file backing their review
+	EvidenceAnchored Name = "EvidenceAnchored"
+)
+
+// Event is the payload of every event emitted by the contract
+type Event struct {
+	Version   int      `json:"version"`              // payload schema version, see Version
+	Name      Name     `json:"name"`                 // same as the chaincode event name
+	TxID      string   `json:"tx_id"`                // transaction that made the change
+	ReviewID  string   `json:"review_id"`            // review that was changed, or whose comments or votes were
+	CommentID string   `json:"comment_id,omitempty"` // set for comment events, and votes on a comment
+	Actor     string   `json:"actor"`                // user that made the change
+	Changed   []string `json:"changed,omitempty"`    // names of the JSON fields that changed
+}
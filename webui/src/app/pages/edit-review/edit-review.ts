@@ -8,6 +8,42 @@ import {MatSnackBar} from '@angular/material/snack-bar';
 import {Review} from '@app/interfaces';
 import {CommonModule} from '@angular/common';
 import {ulid} from 'ulid';
+import {Observable, switchMap} from 'rxjs';
+
+// ReviewInput of the CreateReviewV2 transaction
+interface ReviewInput {
+  id: string;
+  title: string;
+  website: string;
+  summary: string;
+  rating: number;
+  country: string;
+  state?: string;
+  locality?: string;
+  positives?: string[];
+  negatives?: string[];
+  extra_info?: Record<string, string>;
+}
+
+// ReviewPrivate the chaincode reads from the transient map
+interface ReviewPrivate {
+  id: string;
+  email?: string;
+  phone?: string;
+  extra_info?: Record<string, string>;
+  salt: string; // random, at least 16 chars
+}
+
+// the part of the Config of the GetConfig transaction the form needs
+interface ChaincodeConfig {
+  private_extra_info_keys?: string[];
+}
+
+// randomSalt returns 32 random hex chars
+function randomSalt(): string {
+  const bytes = crypto.getRandomValues(new Uint8Array(16));
+  return Array.from(bytes, (b) => b.toString(16).padStart(2, '0')).join('');
+}
 
 interface ReviewForm extends Review {
   positivesStr: string;
@@ -36,6 +72,7 @@ export class EditReview {
   private authService = inject(AuthService);
   private snackBar = inject(MatSnackBar);
   action = `${environment.fabricProxy}/default/assetcc/submit-transaction`;
+  private chaincodeUrl = `${environment.fabricProxy}/${environment.chaincode.channelId}/${environment.chaincode.name}`;
 
   @Input('id') id!: string;
 
@@ -173,33 +210,47 @@ export class EditReview {
     }
   }
 
+  // createReview submits CreateReviewV2. Contact details and the extra info keys the chaincode config
+  // declares private never reach the ledger: they're passed in the transient map under review_private,
+  // and stored in the private data collection of the user's org, with a random salt
   createReview(review: Review) {
-    const func = 'CreateReview';
-    const args = [
-      review.id,
-      review.title,
-      review.website,
-      review.summary,
-      review.country,
-      review.state,
-      review.locality,
-      review.email,
-      review.phone,
-      `${JSON.stringify(review.positives)}`,
-      `${JSON.stringify(review.negatives)}`,
-      `${JSON.stringify(review.extra_info)}`,
-      `${review.rating}`,
-    ];
-
-    console.log(args);
-
-    this.http
-      .post(
-        `${environment.fabricProxy}/${environment.chaincode.channelId}/${environment.chaincode.name}/submit-transaction`,
-        {
-          func,
-          args,
-        },
+    this.evaluate<ChaincodeConfig>('GetConfig', [])
+      .pipe(
+        switchMap((config) => {
+          const privateKeys = config.private_extra_info_keys ?? [];
+          const extraInfo: Record<string, string> = {};
+          const privateExtraInfo: Record<string, string> = {};
+          for (const [key, value] of Object.entries(review.extra_info ?? {})) {
+            (privateKeys.includes(key) ? privateExtraInfo : extraInfo)[key] = `${value}`;
+          }
+
+          const input: ReviewInput = {
+            id: review.id,
+            title: review.title,
+            website: review.website,
+            summary: review.summary,
+            rating: Number(review.rating),
+            country: review.country,
+            state: review.state,
+            locality: review.locality,
+            positives: review.positives,
+            negatives: review.negatives,
+            extra_info: extraInfo,
+          };
+          const reviewPrivate: ReviewPrivate = {
+            id: review.id,
+            email: review.email || undefined,
+            phone: review.phone || undefined,
+            extra_info: Object.keys(privateExtraInfo).length ? privateExtraInfo : undefined,
+            salt: randomSalt(),
+          };
+
+          return this.http.post(`${this.chaincodeUrl}/submit-transaction`, {
+            func: 'CreateReviewV2',
+            args: [JSON.stringify(input)],
+            transient: {review_private: JSON.stringify(reviewPrivate)},
+          });
+        }),
       )
       .subscribe({
         next: (res) => {
@@ -215,6 +266,10 @@ export class EditReview {
       });
   }
 
+  private evaluate<T>(func: string, args: string[]): Observable<T> {
+    return this.http.post<T>(`${this.chaincodeUrl}/evaluate-transaction`, {func, args});
+  }
+
   editReview(review: Review): void {
     // For now, just show the review in the snackbar
     this.snackBar.open('Review processed: ' + review.id, 'X', {duration: 2000});
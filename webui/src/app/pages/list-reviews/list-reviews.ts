@@ -1,7 +1,8 @@
 import {CommonModule} from '@angular/common';
 import {HttpClient} from '@angular/common/http';
-import {Component, inject} from '@angular/core';
+import {Component, inject, signal} from '@angular/core';
 import {rxResource} from '@angular/core/rxjs-interop';
+import {forkJoin, map, Observable, of, switchMap} from 'rxjs';
 import {Review} from '@app/interfaces';
 import {environment} from '@env';
 import {MatButtonModule} from '@angular/material/button';
@@ -11,17 +12,29 @@ import {ExpandableTableComponent, EditorComponent} from 'ng-essential';
 import {MatButtonToggleModule} from '@angular/material/button-toggle';
 import {RouterModule} from '@angular/router';
 
-interface ReviewsResponse {
-  total_rows: number;
-  offset: number;
-  rows: Row[];
+// PaginatedQueryResult of the ReadReviewsPage transaction
+interface ReviewsPage {
+  records: {Key: string; Record: Review}[];
+  fetched_count: number;
+  bookmark: string; // fetches the next page, empty on the last one
+}
+
+// VoteTally of the GetVoteTally transaction
+interface VoteTally {
+  review_id: string;
+  upvotes: number;
+  downvotes: number;
+  score: number;
 }
 
 interface Row {
-  id: string;
-  key: string;
-  value: {rev: string};
-  doc: Review;
+  review: Review;
+  tally: VoteTally;
+}
+
+interface ReviewsResponse {
+  rows: Row[];
+  bookmark: string;
 }
 
 @Component({
@@ -42,31 +55,55 @@ interface Row {
 export class ListReviews {
   private http = inject(HttpClient);
 
-  private opts = {
-    headers: {
-      'authorization': ``, // skip token on couchdb readonly endpoints
-    },
-  };
+  private evaluateUrl = `${environment.fabricProxy}/${environment.chaincode.channelId}/${environment.chaincode.name}/evaluate-transaction`;
 
+  pageSize = 50;
+  bookmark = signal(''); // of the page shown, empty for the first one
+
+  // deleted and hidden reviews are left out of pages, so a page may be short, even empty, before the last one
   reviewsResponse = rxResource({
-    // stream: () => // v20
-    loader: () =>
-      this.http.get<ReviewsResponse>(
-        `${environment.couchdb}/${environment.chaincode.channelId}_${environment.chaincode.name}/_all_docs?include_docs=true`,
-        this.opts,
+    request: () => this.bookmark(),
+    loader: ({request: bookmark}) =>
+      this.evaluate<ReviewsPage>('ReadReviewsPage', [`${this.pageSize}`, bookmark, 'false']).pipe(
+        switchMap((page): Observable<ReviewsResponse> => {
+          const records = page.records ?? [];
+          if (records.length === 0) {
+            return of({rows: [], bookmark: page.bookmark});
+          }
+          return forkJoin(
+            records.map(({Record: review}) =>
+              this.evaluate<VoteTally>('GetVoteTally', [review.id, '']).pipe(map((tally) => ({review, tally}))),
+            ),
+          ).pipe(map((rows) => ({rows, bookmark: page.bookmark})));
+        }),
       ),
   });
 
+  private evaluate<T>(func: string, args: string[]): Observable<T> {
+    return this.http.post<T>(this.evaluateUrl, {func, args});
+  }
+
+  nextPage() {
+    const next = this.reviewsResponse.value()?.bookmark;
+    if (next) {
+      this.bookmark.set(next);
+    }
+  }
+
+  firstPage() {
+    this.bookmark.set('');
+  }
+
   columns = ['website', 'title', 'rating', 'summary', 'positives', 'negatives', 'age', 'votes'];
   cellDefs = [
-    'doc.website',
-    'doc.title | slice:0:48',
-    'doc.rating',
-    'doc.summary | slice:0:96',
-    'doc.positives',
-    'doc.negatives',
-    'doc.id | ulidToDate | timeago',
-    'doc.votes[*].value | sum',
+    'review.website',
+    'review.title | slice:0:48',
+    'review.rating',
+    'review.summary | slice:0:96',
+    'review.positives',
+    'review.negatives',
+    'review.id | ulidToDate | timeago',
+    'tally.score',
   ];
 
   currentExpandedRow?: Row;